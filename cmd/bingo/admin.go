@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/overrides"
+	"github.com/n6g7/nomtail/pkg/log"
+)
+
+// Serves admin actions, they require the admin token.
+type adminHandler struct {
+	logger    *log.Logger
+	backends  []*nameserverBackend
+	overrides *overrides.Overrides
+	conf      atomic.Pointer[config.Config]
+}
+
+func newAdminHandler(logger *log.Logger, backends []*nameserverBackend, overrides *overrides.Overrides, conf *config.Config) *adminHandler {
+	h := &adminHandler{
+		logger:    logger.With("component", "admin"),
+		backends:  backends,
+		overrides: overrides,
+	}
+	h.conf.Store(conf)
+	return h
+}
+
+func (h *adminHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/v1/sync", h.authorized(h.syncAll))
+	mux.HandleFunc("POST /api/v1/backends/{name}/resync", h.authorized(h.resync))
+	mux.HandleFunc("POST /api/v1/backends/{name}/domains/{domain}/retarget", h.authorized(h.retarget))
+	mux.HandleFunc("POST /api/v1/pause", h.authorized(h.pause))
+	mux.HandleFunc("POST /api/v1/resume", h.authorized(h.resume))
+	mux.HandleFunc("PUT /api/v1/rules/excluded/{domain}", h.authorized(h.exclude))
+	mux.HandleFunc("DELETE /api/v1/rules/excluded/{domain}", h.authorized(h.include))
+	mux.HandleFunc("PUT /api/v1/rules/pins/{domain}", h.authorized(h.pin))
+	mux.HandleFunc("DELETE /api/v1/rules/pins/{domain}", h.authorized(h.unpin))
+}
+
+func (h *adminHandler) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.conf.Load().Admin.Token == "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin actions are disabled"})
+			return
+		}
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(h.conf.Load().Admin.Token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid admin token"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Polls the nameservers again and applies changes right away, without waiting
+// for the reconciliation timeout.
+func (h *adminHandler) syncAll(w http.ResponseWriter, r *http.Request) {
+	h.logger.Info("sync requested")
+	for _, backend := range h.backends {
+		backend.sync()
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "sync requested"})
+}
+
+// Same as syncAll, for a single backend.
+func (h *adminHandler) resync(w http.ResponseWriter, r *http.Request) {
+	backend := findBackend(h.backends, r.PathValue("name"))
+	if backend == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown backend"})
+		return
+	}
+	h.logger.Info("resync requested", "backend", backend.name)
+	backend.sync()
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "resync requested"})
+}
+
+// Points the domain's record at a new target, updating it in place, or
+// deleting and recreating it with ?recreate=true.
+func (h *adminHandler) retarget(w http.ResponseWriter, r *http.Request) {
+	backend := findBackend(h.backends, r.PathValue("name"))
+	if backend == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown backend"})
+		return
+	}
+	domain := r.PathValue("domain")
+	if !backend.manages(domain) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "domain not managed by this backend"})
+		return
+	}
+	recreate := r.URL.Query().Get("recreate") == "true"
+	h.logger.Info("retarget requested", "backend", backend.name, "domain", domain, "recreate", recreate)
+	if recreate {
+		backend.reconciler.MarkForDeletion(domain)
+	} else {
+		backend.reconciler.MarkForRetarget(domain)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "retarget requested"})
+}
+
+// Stops changing records on every backend, eg. during maintenance windows.
+func (h *adminHandler) pause(w http.ResponseWriter, r *http.Request) {
+	for _, backend := range h.backends {
+		backend.reconciler.Pause()
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "paused"})
+}
+
+func (h *adminHandler) resume(w http.ResponseWriter, r *http.Request) {
+	for _, backend := range h.backends {
+		backend.reconciler.Resume()
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "resumed"})
+}
+
+// Applies a rule change, then diffs every backend again.
+func (h *adminHandler) changeRule(w http.ResponseWriter, change func() error, msg string, args ...any) {
+	if err := change(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.logger.Info(msg, args...)
+	for _, backend := range h.backends {
+		backend.reconciler.ForceSync()
+	}
+	writeJSON(w, http.StatusOK, h.overrides.Snapshot())
+}
+
+func (h *adminHandler) exclude(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+	h.changeRule(w, func() error { return h.overrides.Exclude(domain) }, "excluded domain", "domain", domain)
+}
+
+func (h *adminHandler) include(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+	h.changeRule(w, func() error { return h.overrides.Include(domain) }, "removed domain exclusion", "domain", domain)
+}
+
+func (h *adminHandler) pin(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+	body := struct {
+		Target string `json:"target"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body: " + err.Error()})
+		return
+	}
+	h.changeRule(w, func() error { return h.overrides.Pin(domain, body.Target) }, "pinned domain", "domain", domain, "target", body.Target)
+}
+
+func (h *adminHandler) unpin(w http.ResponseWriter, r *http.Request) {
+	domain := r.PathValue("domain")
+	h.changeRule(w, func() error { return h.overrides.Unpin(domain) }, "unpinned domain", "domain", domain)
+}
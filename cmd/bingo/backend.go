@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/n6g7/bingo/internal/audit"
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/dnsname"
+	"github.com/n6g7/bingo/internal/health"
+	"github.com/n6g7/bingo/internal/leader"
+	"github.com/n6g7/bingo/internal/nameserver"
+	"github.com/n6g7/bingo/internal/notify"
+	"github.com/n6g7/bingo/internal/overrides"
+	"github.com/n6g7/bingo/internal/proxy"
+	"github.com/n6g7/bingo/internal/reconcile"
+	"github.com/n6g7/bingo/internal/state"
+	"github.com/n6g7/bingo/internal/tracing"
+	"github.com/n6g7/nomtail/pkg/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A nameserver backend along with its own reconciler and polling loop.
+type nameserverBackend struct {
+	logger       *log.Logger
+	name         string
+	ns           nameserver.Nameserver
+	prox         proxy.Proxy
+	reconciler   *reconcile.Reconciler
+	pollInterval time.Duration
+	refreshChan  chan struct{}
+	syncChan     chan struct{}
+	reloadChan   chan backendReload
+	overrides    *overrides.Overrides
+	health       *health.Check // nameserver record listing
+	conf         atomic.Pointer[config.Config]
+}
+
+// Settings of a backend that change when the config is reloaded.
+type backendReload struct {
+	// Replaces the nameserver if not nil.
+	ns           nameserver.Nameserver
+	pollInterval time.Duration
+}
+
+func newNameserverBackend(
+	logger *log.Logger,
+	name string,
+	ns nameserver.Nameserver,
+	prox proxy.Proxy,
+	store *state.Store,
+	elector *leader.Elector,
+	overrides *overrides.Overrides,
+	notifier *notify.Notifier,
+	auditLog *audit.Log,
+	pollInterval time.Duration,
+	conf *config.Config,
+) *nameserverBackend {
+	b := &nameserverBackend{
+		logger:       logger.With("backend", name),
+		name:         name,
+		ns:           ns,
+		prox:         prox,
+		reconciler:   reconcile.NewReconciler(logger, name, ns, prox, store, elector, overrides, notifier, auditLog, conf),
+		pollInterval: pollInterval,
+		refreshChan:  make(chan struct{}, 1),
+		syncChan:     make(chan struct{}, 1),
+		reloadChan:   make(chan backendReload),
+		overrides:    overrides,
+		health:       health.NewCheck(),
+	}
+	b.conf.Store(conf)
+	return b
+}
+
+// Applies a reloaded config, the nameserver is only replaced if its own
+// settings changed.
+func (b *nameserverBackend) reload(conf *config.Config, reload backendReload) {
+	b.conf.Store(conf)
+	b.reconciler.SetConfig(conf)
+	b.reloadChan <- reload
+}
+
+// Returns whether the domain is a service domain routed to this backend, and
+// not excluded at runtime.
+func (b *nameserverBackend) manages(domain string) bool {
+	return b.managesFrom(domain, "")
+}
+
+// Same as manages, the domain must also be provided by one of its service
+// domain's proxy sources unless source is empty.
+func (b *nameserverBackend) managesFrom(domain string, source config.ProxyType) bool {
+	// A single config snapshot, the backend's config is reloaded before the
+	// main loop's
+	rule := b.conf.Load().DomainRule(domain)
+	if rule == nil || !rule.HasNameserver(b.name) {
+		return false
+	}
+	if source != "" && !rule.HasProxy(source) {
+		return false
+	}
+	normalized, err := dnsname.Normalize(domain)
+	return err == nil && !b.overrides.Excluded(normalized)
+}
+
+// Asks for the nameserver records to be checked again and reconciled right
+// away, without waiting for the reconciliation timeout.
+func (b *nameserverBackend) sync() {
+	select {
+	case b.syncChan <- struct{}{}:
+	default:
+	}
+}
+
+// Asks for the nameserver records to be checked again as soon as possible.
+func (b *nameserverBackend) refresh() {
+	select {
+	case b.refreshChan <- struct{}{}:
+	default:
+	}
+}
+
+func (b *nameserverBackend) onTick() {
+	ctx, span := tracer.Start(context.Background(), "nameserver.poll", trace.WithAttributes(attribute.String("backend", b.name)))
+	records, err := b.ns.ListRecords(ctx)
+	tracing.End(span, err)
+	if err != nil {
+		b.logger.Error("error loading records from nameserver", "err", err)
+		b.health.Fail(err)
+		return
+	}
+	b.health.Succeed()
+	managedRecords := []nameserver.Record{}
+	for _, record := range records {
+		// We only manage service domains routed to this backend
+		if !b.manages(record.Name) {
+			continue
+		}
+		managedRecords = append(managedRecords, record)
+	}
+	b.reconciler.SetNameserverRecords(managedRecords)
+}
+
+// Must be called from the polling loop.
+func (b *nameserverBackend) apply(reload backendReload) {
+	b.pollInterval = reload.pollInterval
+	if reload.ns != nil {
+		b.ns = reload.ns
+	}
+}
+
+// Keeps trying to initialize the nameserver, other backends keep running
+// meanwhile. The reconciler only uses it once it's initialized.
+func (b *nameserverBackend) init() {
+	for {
+		err := b.ns.Init(context.Background())
+		if err == nil {
+			break
+		}
+		b.logger.Error("nameserver backend initialization failed, will attempt again", "err", err, "next_attempt_in", b.pollInterval)
+		b.health.Fail(fmt.Errorf("initialization failed: %w", err))
+		// A reloaded config may fix the settings
+		select {
+		case <-time.After(b.pollInterval):
+		case reload := <-b.reloadChan:
+			b.apply(reload)
+		}
+	}
+	b.logger.Info("initialized nameserver backend")
+	b.reconciler.SetNameserver(b.ns)
+}
+
+func (b *nameserverBackend) run() {
+	// Idle until records are listed
+	go b.reconciler.Run()
+
+	b.init()
+
+	// Initial tick
+	b.onTick()
+
+	tick := time.NewTicker(b.pollInterval)
+	for {
+		select {
+		case <-tick.C:
+			b.onTick()
+		case <-b.refreshChan:
+			b.onTick()
+		case <-b.syncChan:
+			b.onTick()
+			b.reconciler.ForceSync()
+		case reload := <-b.reloadChan:
+			b.apply(reload)
+			if reload.ns != nil {
+				b.init()
+			}
+			tick.Reset(b.pollInterval)
+			// Domains may have been added to or removed from the backend
+			b.onTick()
+		}
+	}
+}
@@ -1,27 +1,45 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/audit"
 	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/discovery"
+	"github.com/n6g7/bingo/internal/health"
+	"github.com/n6g7/bingo/internal/leader"
 	"github.com/n6g7/bingo/internal/nameserver"
+	"github.com/n6g7/bingo/internal/notify"
+	"github.com/n6g7/bingo/internal/overrides"
 	"github.com/n6g7/bingo/internal/proxy"
-	"github.com/n6g7/bingo/internal/reconcile"
+	"github.com/n6g7/bingo/internal/state"
+	"github.com/n6g7/bingo/internal/tracing"
 	"github.com/n6g7/nomtail/pkg/log"
 	"github.com/n6g7/nomtail/pkg/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
 )
 
+var tracer = otel.Tracer("github.com/n6g7/bingo/cmd/bingo")
+
 func main() {
 	logger := log.SetupLogger()
 	logger.Info("Bingo starting", "version", version.Display(), "go_runtime", runtime.Version())
 
-	conf, err := config.Load()
+	configPath := flag.String("config", os.Getenv("BINGO_CONFIG"), "path of a YAML, TOML or HCL config file")
+	flag.Parse()
+
+	conf, err := config.Load(*configPath)
 	if err != nil {
 		logger.Error("failed to load config", "err", err)
 		os.Exit(1)
@@ -30,109 +48,309 @@ func main() {
 	log.SetLevel(conf.LogLevel)
 	logger.Debug("loaded config", "config", conf)
 
-	// Load proxy
-	var prox proxy.Proxy
+	// Load proxies
+	proxies := map[string]proxy.Proxy{}
+	for _, source := range conf.Proxy.Sources() {
+		proxies[source.Name] = loadProxy(logger, conf, source)
+	}
+	prox := proxy.NewMultiProxy(logger, proxySources(conf, proxies))
+
+	// Load state
+	var store *state.Store
+
+	if conf.State.Path != "" {
+		store, err = state.Load(logger, conf.State.Path)
+		if err != nil {
+			logger.Error("failed to load state", "err", err)
+			os.Exit(1)
+		}
+		go store.Run(conf.State.FlushInterval)
+	}
+
+	identity := instanceIdentity(logger, conf)
+
+	// Tracing
+	flushTraces := func(context.Context) error { return nil }
+
+	if conf.Tracing.Enabled {
+		flushTraces, err = tracing.Setup(conf.Tracing, identity)
+		if err != nil {
+			logger.Error("failed to set up tracing", "err", err)
+			os.Exit(1)
+		}
+		logger.Info("exporting traces", "endpoint", conf.Tracing.Endpoint, "sample_ratio", conf.Tracing.SampleRatio)
+	}
+
+	// Leader election
+	var elector *leader.Elector
+
+	if conf.LeaderElection.Type != config.NoLeaderElection {
+		elector = leader.NewElector(logger, loadLock(logger, conf, identity), conf.LeaderElection.RetryInterval, conf.LeaderElection.TTL)
+		go elector.Run()
+	}
+	go stopOnSignal(logger, store, elector, flushTraces)
+
+	// Change notifications
+	var notifier *notify.Notifier
+
+	if len(conf.Notifications.WebhookURLs) > 0 {
+		notifier, err = notify.NewNotifier(logger, conf.Notifications)
+		if err != nil {
+			logger.Error("failed to set up notifications", "err", err)
+			os.Exit(1)
+		}
+		go notifier.Run()
+	}
+
+	// Audit log
+	var auditLog *audit.Log
+
+	if conf.Audit.Path != "" {
+		auditLog, err = audit.Open(conf.Audit, identity)
+		if err != nil {
+			logger.Error("failed to open audit log", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	// Load nameservers
+	backends := []*nameserverBackend{}
+	rules := overrides.New()
+
+	for _, instance := range conf.Nameserver.Backends() {
+		ns := loadNameserver(logger, instance)
+		backends = append(backends, newNameserverBackend(logger, instance.Name, ns, prox, store, elector, rules, notifier, auditLog, pollInterval(conf, instance), conf))
+	}
+
+	proxyHealth := health.NewCheck()
+	healthEndpoints := newHealthHandler(elector, proxyHealth, backends, conf)
+	adminEndpoints := newAdminHandler(logger, backends, rules, conf)
+	go metrics(
+		logger,
+		conf,
+		healthEndpoints,
+		&statusHandler{prox, backends, rules},
+		adminEndpoints,
+		dashboardHandler{},
+	)
+
+	// Config reloads
+	reloader := newReloader(logger, *configPath, conf, proxies, backends, healthEndpoints, adminEndpoints)
+	go reloader.run()
+
+	err = bingo(logger, backends, prox, proxyHealth, reloader.proxyReloads, conf)
+	if err != nil {
+		logger.Error("Bingo stopped with an error", "err", err)
+		os.Exit(1)
+	}
+}
 
-	switch conf.Proxy.Type {
+func loadProxy(logger *log.Logger, conf *config.Config, source config.ProxyInstance) proxy.Proxy {
+	serviceDomain := conf.ProxyServiceDomain(source.Name)
+	switch source.Type {
 	case config.Fabio:
-		prox = proxy.NewFabioProxy(conf.Proxy.Fabio)
+		return proxy.NewFabioProxy(logger, source.Name, source.Fabio, serviceDomain, loadDiscoverer(logger, conf, source.Fabio.Discovery, source.Fabio.Hosts))
 	case config.Traefik:
-		prox = proxy.NewTraefikProxy(conf.Proxy.Traefik)
+		return proxy.NewTraefikProxy(logger, source.Name, source.Traefik, serviceDomain, loadDiscoverer(logger, conf, source.Traefik.Discovery, source.Traefik.Hosts))
 	default:
-		logger.Error("unknown proxy type", "type", conf.Proxy.Type)
+		logger.Error("unknown proxy type", "type", source.Type)
 		os.Exit(1)
 	}
+	return nil
+}
 
-	// Load nameserver
+// Returns the configured proxy sources, in order.
+func proxySources(conf *config.Config, proxies map[string]proxy.Proxy) []proxy.Source {
+	sources := []proxy.Source{}
+	for _, source := range conf.Proxy.Sources() {
+		sources = append(sources, proxy.Source{
+			Name:     source.Name,
+			Priority: source.Priority(),
+			Proxy:    proxies[source.Name],
+		})
+	}
+	return sources
+}
+
+func loadNameserver(logger *log.Logger, instance config.NameserverInstance) nameserver.Nameserver {
 	var ns nameserver.Nameserver
+	var host string
 
-	switch conf.Nameserver.Type {
+	logger = logger.With("backend", instance.Name)
+	switch instance.Type {
 	case config.Pihole:
-		ns = nameserver.NewPiholeNS(logger, conf.Nameserver.Pihole)
+		ns = nameserver.NewPiholeNS(logger, instance.Pihole)
+		if u, err := url.Parse(instance.Pihole.URL); err == nil {
+			host = u.Host
+		}
 	case config.Route53:
-		ns = nameserver.NewRoute53NS(logger, conf.Nameserver.Route53)
+		ns = nameserver.NewRoute53NS(logger, instance.Route53)
+		host = "route53.amazonaws.com"
 	default:
-		logger.Error("unknown nameserver type", "type", conf.Nameserver.Type)
+		logger.Error("unknown nameserver type", "type", instance.Type)
 		os.Exit(1)
 	}
+	return nameserver.Instrument(ns, instance.Name, host)
+}
+
+func pollInterval(conf *config.Config, instance config.NameserverInstance) time.Duration {
+	var interval time.Duration
+	switch instance.Type {
+	case config.Pihole:
+		interval = instance.Pihole.PollInterval
+	case config.Route53:
+		interval = instance.Route53.PollInterval
+	}
+	if interval == 0 {
+		return conf.Nameserver.PollInterval
+	}
+	return interval
+}
 
-	go metrics(logger, conf)
+func loadDiscoverer(logger *log.Logger, conf *config.Config, discConf config.DiscoveryConf, hosts []string) discovery.Discoverer {
+	switch discConf.Type {
+	case config.StaticDiscovery:
+		return discovery.NewStaticDiscoverer(hosts)
+	case config.ConsulDiscovery:
+		return discovery.NewConsulDiscoverer(conf.Discovery, discConf.Name, discConf.HostSuffix)
+	case config.DNSDiscovery:
+		return discovery.NewDNSDiscoverer(discConf.Name)
+	case config.NomadDiscovery:
+		return discovery.NewNomadDiscoverer(conf.Discovery, discConf.Name, discConf.HostSuffix)
+	default:
+		logger.Error("unknown discovery type", "type", discConf.Type)
+		os.Exit(1)
+	}
+	return nil
+}
 
-	err = bingo(logger, ns, prox, conf)
+// Identifies this instance in the leadership lock and the audit log.
+func instanceIdentity(logger *log.Logger, conf *config.Config) string {
+	if conf.LeaderElection.Identity != "" {
+		return conf.LeaderElection.Identity
+	}
+	hostname, err := os.Hostname()
 	if err != nil {
-		logger.Error("Bingo stopped with an error", "err", err)
+		logger.Error("failed to get host name for instance identity", "err", err)
 		os.Exit(1)
 	}
+	return hostname
 }
 
-func bingo(logger *log.Logger, ns nameserver.Nameserver, prox proxy.Proxy, conf *config.Config) error {
-	reconciler := reconcile.NewReconciler(logger, ns, prox, conf)
+func loadLock(logger *log.Logger, conf *config.Config, identity string) leader.Lock {
+	switch conf.LeaderElection.Type {
+	case config.ConsulLeaderElection:
+		return leader.NewConsulLock(conf.Discovery.ConsulAddr, string(conf.Discovery.ConsulToken), conf.LeaderElection.ConsulKey, identity, conf.LeaderElection.TTL)
+	case config.KubernetesLeaderElection:
+		lease, err := leader.NewKubernetesLease(conf.LeaderElection.LeaseName, conf.LeaderElection.LeaseNamespace, identity, conf.LeaderElection.TTL)
+		if err != nil {
+			logger.Error("failed to set up Kubernetes lease", "err", err)
+			os.Exit(1)
+		}
+		return lease
+	case config.FileLeaderElection:
+		return leader.NewFileLock(conf.LeaderElection.LockPath, identity)
+	default:
+		logger.Error("unknown leader election type", "type", conf.LeaderElection.Type)
+		os.Exit(1)
+	}
+	return nil
+}
 
-	err := ns.Init()
-	if err != nil {
-		return fmt.Errorf("nameserver backend initialization failed: %w", err)
+// Releases leadership on shutdown, so that a standby takes over right away,
+// and exports pending spans.
+func stopOnSignal(logger *log.Logger, store *state.Store, elector *leader.Elector, flushTraces func(context.Context) error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Info("Bingo stopping", "signal", sig)
+	if store != nil {
+		if err := store.Save(); err != nil {
+			logger.Error("error saving state", "err", err)
+		}
+	}
+	if elector != nil {
+		if err := elector.Release(); err != nil {
+			logger.Error("error releasing leadership", "err", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := flushTraces(ctx); err != nil {
+		logger.Error("error exporting pending spans", "err", err)
 	}
-	logger.Info("initialized nameserver backend", "type", conf.Nameserver.Type)
-	err = prox.Init()
+	os.Exit(0)
+}
+
+func bingo(logger *log.Logger, backends []*nameserverBackend, prox *proxy.MultiProxy, proxyHealth *health.Check, reloads <-chan proxyReload, conf *config.Config) error {
+	err := prox.Init()
 	if err != nil {
 		return fmt.Errorf("proxy backend initialization failed: %w", err)
 	}
-	logger.Info("initialized proxy backend", "type", conf.Proxy.Type)
+	logger.Info("initialized proxy backends", "sources", conf.Proxy.SourceNames())
 
-	go reconciler.Run()
+	// Each nameserver backend is polled and reconciled independently, so that
+	// one failing backend doesn't block the others.
+	for _, backend := range backends {
+		go backend.run()
+	}
 
-	onNameserverTick := func() {
-		records, err := ns.ListRecords()
+	onDiscoveryTick := func() {
+		err := prox.DiscoverHosts()
 		if err != nil {
-			logger.Error("error loading records from nameserver", "err", err)
+			logger.Error("error discovering proxy hosts", "err", err)
 			return
 		}
-		newNSDomains := mapset.NewSet[string]()
-		for _, record := range records {
-			// We only manage service domains
-			if !conf.IsServiceDomain(record.Name) {
-				continue
-			}
-
-			newNSDomains.Add(record.Name)
-			if !prox.IsValidTarget(record.Cname) {
-				logger.Debug("domain points to invalid target, marking it for deletion.", "domain", record.Name, "target", record.Cname)
-				reconciler.MarkForDeletion(record.Name)
-			}
+		// Records pointing at hosts that went away must be retargeted
+		for _, backend := range backends {
+			backend.refresh()
 		}
-		reconciler.SetNameserverDomains(newNSDomains)
 	}
 
 	onProxyTick := func() {
-		services, err := prox.ListServices()
+		ctx, span := tracer.Start(context.Background(), "proxy.poll")
+		services, err := prox.ListServices(ctx)
+		tracing.End(span, err)
 		if err != nil {
 			logger.Error("error loading services from proxy", "err", err)
+			proxyHealth.Fail(err)
 			return
 		}
-		newProxyDomains := mapset.NewSet[string]()
-		for _, service := range services {
-			// We only manage service domains
-			if !conf.IsServiceDomain(service.Domain) {
-				continue
-			}
+		proxyHealth.Succeed()
+		for _, backend := range backends {
+			newProxyDomains := mapset.NewSet[string]()
+			for _, service := range services {
+				// We only manage service domains routed to this backend and
+				// provided by one of the domain's proxy sources
+				if !backend.managesFrom(service.Domain, service.Source) {
+					continue
+				}
 
-			newProxyDomains.Add(service.Domain)
+				newProxyDomains.Add(service.Domain)
+			}
+			backend.reconciler.SetProxyDomains(newProxyDomains)
 		}
-		reconciler.SetProxyDomains(newProxyDomains)
 	}
 
 	// Initial tick
-	onNameserverTick()
 	onProxyTick()
 
 	// Main loop
-	nameserverTick := time.Tick(conf.Nameserver.PollInterval)
-	proxyTick := time.Tick(conf.Proxy.PollInterval)
+	proxyTick := time.NewTicker(conf.Proxy.PollInterval)
+	discoveryTick := time.NewTicker(conf.Discovery.Interval)
 	for {
+		proxyHealth.Beat()
 		select {
-		case <-nameserverTick:
-			onNameserverTick()
-		case <-proxyTick:
+		case <-proxyTick.C:
+			onProxyTick()
+		case <-discoveryTick.C:
+			onDiscoveryTick()
+		case reload := <-reloads:
+			conf = reload.conf
+			prox.SetSources(reload.sources)
+			proxyTick.Reset(conf.Proxy.PollInterval)
+			discoveryTick.Reset(conf.Discovery.Interval)
+			// Domains may have been added or removed
 			onProxyTick()
 		default:
 			time.Sleep(conf.MainLoopTimeout)
@@ -140,14 +358,18 @@ func bingo(logger *log.Logger, ns nameserver.Nameserver, prox proxy.Proxy, conf
 	}
 }
 
-func metrics(logger *log.Logger, conf *config.Config) {
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "{\"healthy\": true}")
-	})
-	http.Handle(conf.Prometheus.MetricsPath, promhttp.Handler())
+// Handlers served next to the metrics.
+type httpHandler interface {
+	register(mux *http.ServeMux)
+}
+
+func metrics(logger *log.Logger, conf *config.Config, handlers ...httpHandler) {
+	mux := http.NewServeMux()
+	for _, handler := range handlers {
+		handler.register(mux)
+	}
+	mux.Handle(conf.Prometheus.MetricsPath, promhttp.Handler())
 
 	logger.Info("starting prometheus exporter", "addr", conf.Prometheus.ListenAddr, "metrics_path", conf.Prometheus.MetricsPath)
-	http.ListenAndServe(conf.Prometheus.ListenAddr, nil)
+	http.ListenAndServe(conf.Prometheus.ListenAddr, mux)
 }
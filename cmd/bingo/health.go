@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/health"
+	"github.com/n6g7/bingo/internal/leader"
+)
+
+type livenessReport struct {
+	Live bool `json:"live"`
+	// Loop name -> whether it ran within the liveness timeout
+	Loops map[string]bool `json:"loops"`
+}
+
+type readinessReport struct {
+	Ready    bool                     `json:"ready"`
+	Leader   bool                     `json:"leader"`
+	Proxy    health.Report            `json:"proxy"`
+	Backends map[string]backendReport `json:"backends"`
+}
+
+type backendReport struct {
+	Status         health.Status `json:"status"`
+	Nameserver     health.Report `json:"nameserver"`
+	Reconciliation health.Report `json:"reconciliation"`
+}
+
+// Serves the liveness and readiness endpoints.
+type healthHandler struct {
+	elector  *leader.Elector
+	proxy    *health.Check // proxy service listing and main loop
+	backends []*nameserverBackend
+	conf     atomic.Pointer[config.Config]
+}
+
+func newHealthHandler(elector *leader.Elector, proxy *health.Check, backends []*nameserverBackend, conf *config.Config) *healthHandler {
+	h := &healthHandler{
+		elector:  elector,
+		proxy:    proxy,
+		backends: backends,
+	}
+	h.conf.Store(conf)
+	return h
+}
+
+// Ready when there's recent data, even if the last attempt failed.
+func isReady(report health.Report) bool {
+	return report.LastSuccess != nil && (report.Status == health.OK || report.Status == health.Failing)
+}
+
+func (h *healthHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("/health", h.health)
+	mux.HandleFunc("/livez", h.livez)
+	mux.HandleFunc("/readyz", h.readyz)
+}
+
+func writeReport(w http.ResponseWriter, ok bool, report any) {
+	if ok {
+		writeJSON(w, http.StatusOK, report)
+	} else {
+		writeJSON(w, http.StatusServiceUnavailable, report)
+	}
+}
+
+func (h *healthHandler) health(w http.ResponseWriter, r *http.Request) {
+	// Every instance is a leader without leader election
+	isLeader := h.elector == nil || h.elector.IsLeader()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "{\"healthy\": true, \"leader\": %t}", isLeader)
+}
+
+// Live as long as every loop keeps running: a stuck loop won't recover without
+// a restart.
+func (h *healthHandler) livez(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	timeout := h.conf.Load().Health.LivenessTimeout
+	report := livenessReport{
+		Live:  true,
+		Loops: map[string]bool{"main": h.proxy.Alive(now, timeout)},
+	}
+	for _, backend := range h.backends {
+		report.Loops[backend.name+".nameserver"] = backend.health.Alive(now, timeout)
+		report.Loops[backend.name+".reconciler"] = backend.reconciler.Health().Alive(now, timeout)
+	}
+	for _, alive := range report.Loops {
+		report.Live = report.Live && alive
+	}
+	writeReport(w, report.Live, report)
+}
+
+// Ready when both proxy services and the records of every nameserver backend
+// were recently listed. Reconciliation failures are reported but don't affect
+// readiness, failed changes are retried.
+func (h *healthHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	staleAfter := h.conf.Load().Health.StaleAfter
+	report := readinessReport{
+		Leader:   h.elector == nil || h.elector.IsLeader(),
+		Proxy:    h.proxy.Report(now, staleAfter),
+		Backends: map[string]backendReport{},
+	}
+	report.Ready = isReady(report.Proxy)
+
+	for _, backend := range h.backends {
+		b := backendReport{
+			Nameserver:     backend.health.Report(now, staleAfter),
+			Reconciliation: backend.reconciler.Health().Report(now, 0),
+		}
+		switch {
+		case !isReady(b.Nameserver):
+			b.Status = b.Nameserver.Status
+		case b.Nameserver.Status == health.Failing || b.Reconciliation.Status == health.Failing:
+			b.Status = health.Failing
+		default:
+			b.Status = health.OK
+		}
+		report.Backends[backend.name] = b
+		report.Ready = report.Ready && isReady(b.Nameserver)
+	}
+
+	writeReport(w, report.Ready, report)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/proxy"
+	"github.com/n6g7/nomtail/pkg/log"
+)
+
+// A reloaded config, along with the proxy sources it configures.
+type proxyReload struct {
+	conf    *config.Config
+	sources []proxy.Source
+}
+
+// Reloads the config on SIGHUP and whenever the config file changes. Only the
+// proxy sources and nameserver backends whose settings changed are rebuilt,
+// reconcilers keep their state.
+type reloader struct {
+	logger       *log.Logger
+	baseLogger   *log.Logger // passed on to rebuilt proxies and nameservers
+	path         string
+	conf         *config.Config
+	proxies      map[string]proxy.Proxy
+	backends     []*nameserverBackend
+	health       *healthHandler
+	admin        *adminHandler
+	requests     chan struct{}
+	proxyReloads chan proxyReload // applied by the main loop
+}
+
+func newReloader(
+	logger *log.Logger,
+	path string,
+	conf *config.Config,
+	proxies map[string]proxy.Proxy,
+	backends []*nameserverBackend,
+	health *healthHandler,
+	admin *adminHandler,
+) *reloader {
+	return &reloader{
+		logger:       logger.With("component", "reloader"),
+		baseLogger:   logger,
+		path:         path,
+		conf:         conf,
+		proxies:      proxies,
+		backends:     backends,
+		health:       health,
+		admin:        admin,
+		requests:     make(chan struct{}, 1),
+		proxyReloads: make(chan proxyReload),
+	}
+}
+
+// Asks for the config to be reloaded, requests made during a reload are merged
+// into a single one.
+func (r *reloader) request() {
+	select {
+	case r.requests <- struct{}{}:
+	default:
+	}
+}
+
+func (r *reloader) run() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for sig := range signals {
+			r.logger.Info("reloading config", "signal", sig)
+			r.request()
+		}
+	}()
+
+	if r.path != "" {
+		config.Watch(r.path, func() {
+			r.logger.Info("config file changed, reloading config", "path", r.path)
+			r.request()
+		})
+	}
+
+	for range r.requests {
+		if err := r.reload(); err != nil {
+			r.logger.Error("config reload failed, keeping the current config", "err", err)
+		}
+	}
+}
+
+func (r *reloader) reload() error {
+	conf, err := config.Load(r.path)
+	if err != nil {
+		return err
+	}
+	r.keepFixedSettings(conf)
+
+	// Proxy sources are rebuilt first, a new source that can't be initialized
+	// fails the reload, as it would fail startup.
+	proxies := map[string]proxy.Proxy{}
+	for _, source := range conf.Proxy.Sources() {
+		current, ok := r.proxies[source.Name]
+		if ok && reflect.DeepEqual(proxySettings(r.conf, source.Name), proxySettings(conf, source.Name)) {
+			proxies[source.Name] = current
+			continue
+		}
+		rebuilt := loadProxy(r.baseLogger, conf, source)
+		if err := rebuilt.Init(); err != nil {
+			return fmt.Errorf("%s proxy initialization failed: %w", source.Name, err)
+		}
+		r.logger.Info("rebuilt proxy source", "source", source.Name)
+		proxies[source.Name] = rebuilt
+	}
+
+	log.SetLevel(conf.LogLevel)
+	r.health.conf.Store(conf)
+	r.admin.conf.Store(conf)
+
+	// Nameservers that can't be initialized are retried by their backend, as
+	// on startup.
+	for _, backend := range r.backends {
+		instance := conf.Nameserver.Backend(backend.name)
+		reload := backendReload{pollInterval: pollInterval(conf, *instance)}
+		if !reflect.DeepEqual(nameserverSettings(r.conf, backend.name), nameserverSettings(conf, backend.name)) {
+			r.logger.Info("rebuilding nameserver backend", "backend", backend.name)
+			reload.ns = loadNameserver(r.baseLogger, *instance)
+		}
+		backend.reload(conf, reload)
+	}
+
+	r.proxyReloads <- proxyReload{conf, proxySources(conf, proxies)}
+
+	r.conf = conf
+	r.proxies = proxies
+	r.logger.Info("reloaded config")
+	r.logger.Debug("loaded config", "config", conf)
+	return nil
+}
+
+// Settings that can't change without a restart keep their current values.
+func (r *reloader) keepFixedSettings(conf *config.Config) {
+	keep(r.logger, "PROMETHEUS_*", r.conf.Prometheus, &conf.Prometheus)
+	keep(r.logger, "STATE_*", r.conf.State, &conf.State)
+	keep(r.logger, "LEADER_ELECTION_*", r.conf.LeaderElection, &conf.LeaderElection)
+	keep(r.logger, "NOTIFY_*", r.conf.Notifications, &conf.Notifications)
+	keep(r.logger, "AUDIT_LOG_*", r.conf.Audit, &conf.Audit)
+	keep(r.logger, "TRACING_*", r.conf.Tracing, &conf.Tracing)
+	keep(r.logger, "NAMESERVER_TYPE", r.conf.Nameserver.Types, &conf.Nameserver.Types)
+	// Nameserver instances can't be added, removed or change type, but their
+	// settings can change.
+	if !reflect.DeepEqual(backendTypes(r.conf), backendTypes(conf)) {
+		r.logger.Warn("settings can't change without a restart, keeping their current value", "settings", "nameserver instances")
+		conf.Nameserver.Instances = r.conf.Nameserver.Instances
+	}
+}
+
+// Nameserver backend name -> type.
+func backendTypes(conf *config.Config) map[string]config.NameserverType {
+	types := map[string]config.NameserverType{}
+	for _, backend := range conf.Nameserver.Backends() {
+		types[backend.Name] = backend.Type
+	}
+	return types
+}
+
+func keep[T any](logger *log.Logger, settings string, current T, reloaded *T) {
+	if !reflect.DeepEqual(current, *reloaded) {
+		logger.Warn("settings can't change without a restart, keeping their current value", "settings", settings)
+		*reloaded = current
+	}
+}
+
+// The settings the named proxy source is built from, its priority aside.
+func proxySettings(conf *config.Config, name string) []any {
+	source := conf.Proxy.Source(name)
+	if source == nil {
+		return nil
+	}
+	discovery := conf.Discovery
+	discovery.Interval = 0
+	settings := []any{source.Type, conf.ProxyServiceDomain(name), discovery}
+	switch source.Type {
+	case config.Fabio:
+		fabio := source.Fabio
+		fabio.Priority = 0
+		settings = append(settings, fabio)
+	case config.Traefik:
+		traefik := source.Traefik
+		traefik.Priority = 0
+		settings = append(settings, traefik)
+	}
+	return settings
+}
+
+// The settings the named nameserver is built from, its poll interval aside.
+func nameserverSettings(conf *config.Config, name string) any {
+	backend := conf.Nameserver.Backend(name)
+	if backend == nil {
+		return nil
+	}
+	switch backend.Type {
+	case config.Pihole:
+		pihole := backend.Pihole
+		pihole.PollInterval = 0
+		return pihole
+	case config.Route53:
+		route53 := backend.Route53
+		route53.PollInterval = 0
+		return route53
+	}
+	return nil
+}
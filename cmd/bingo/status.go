@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/n6g7/bingo/internal/overrides"
+	"github.com/n6g7/bingo/internal/proxy"
+	"github.com/n6g7/bingo/internal/reconcile"
+)
+
+type serviceStatus struct {
+	proxy.Service
+	// Nameserver backends managing a record for the service's domain
+	Backends []string `json:"backends"`
+}
+
+// Serves a read-only JSON view of the proxy services and of each reconciler's
+// state.
+type statusHandler struct {
+	prox      *proxy.MultiProxy
+	backends  []*nameserverBackend
+	overrides *overrides.Overrides
+}
+
+func (h *statusHandler) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/services", h.services)
+	mux.HandleFunc("GET /api/v1/backends", h.listBackends)
+	mux.HandleFunc("GET /api/v1/backends/{name}", h.backend)
+	mux.HandleFunc("GET /api/v1/rules", h.rules)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (h *statusHandler) services(w http.ResponseWriter, r *http.Request) {
+	services := []serviceStatus{}
+	for _, service := range h.prox.Services() {
+		s := serviceStatus{Service: service, Backends: []string{}}
+		for _, backend := range h.backends {
+			if backend.manages(service.Domain) {
+				s.Backends = append(s.Backends, backend.name)
+			}
+		}
+		services = append(services, s)
+	}
+	writeJSON(w, http.StatusOK, services)
+}
+
+func (h *statusHandler) listBackends(w http.ResponseWriter, r *http.Request) {
+	statuses := map[string]reconcile.Status{}
+	for _, backend := range h.backends {
+		statuses[backend.name] = backend.reconciler.Status()
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func findBackend(backends []*nameserverBackend, name string) *nameserverBackend {
+	for _, backend := range backends {
+		if backend.name == name {
+			return backend
+		}
+	}
+	return nil
+}
+
+func (h *statusHandler) backend(w http.ResponseWriter, r *http.Request) {
+	backend := findBackend(h.backends, r.PathValue("name"))
+	if backend == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown backend"})
+		return
+	}
+	writeJSON(w, http.StatusOK, backend.reconciler.Status())
+}
+
+// Lists the domain rules added at runtime.
+func (h *statusHandler) rules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.overrides.Snapshot())
+}
@@ -0,0 +1,141 @@
+// Package audit writes every record change bingo attempts to an append-only
+// JSON Lines file, rotated by size.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/n6g7/bingo/internal/config"
+)
+
+// Why a record is changed.
+type Reason = string
+
+const (
+	// The domain appeared on the proxies.
+	NewServiceReason Reason = "new_service"
+	// The domain vanished from the proxies.
+	VanishedServiceReason Reason = "vanished_service"
+	// The record points at a proxy host that doesn't serve the domain.
+	InvalidTargetReason Reason = "invalid_target"
+	// The record's type, values or TTL differ from the desired ones.
+	DriftReason Reason = "drift"
+	// Retarget requested through the admin API.
+	AdminReason Reason = "admin"
+)
+
+type Result = string
+
+const (
+	Success Result = "success"
+	Failure Result = "failure"
+	// Deletion refused because too many records would have been deleted at once.
+	Refused Result = "refused"
+)
+
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Instance  string    `json:"instance"`
+	Backend   string    `json:"backend"`
+	Operation string    `json:"operation"`
+	Domain    string    `json:"domain"`
+	OldTarget string    `json:"old_target,omitempty"`
+	NewTarget string    `json:"new_target,omitempty"`
+	Reason    Reason    `json:"reason"`
+	Result    Result    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type Log struct {
+	mu         sync.Mutex
+	path       string
+	instance   string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// Opens the audit log at the configured path, appending to it if it exists.
+// Entries are attributed to the instance.
+func Open(conf config.Audit, instance string) (*Log, error) {
+	l := &Log{
+		path:       conf.Path,
+		instance:   instance,
+		maxSize:    conf.MaxSize,
+		maxBackups: conf.MaxBackups,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading audit log size: %w", err)
+	}
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// Appends an entry, rotating the file first if it would grow past the maximum
+// size.
+func (l *Log) Write(entry Entry) error {
+	entry.Instance = l.instance
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("error encoding audit entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("error writing audit entry: %w", err)
+	}
+	return nil
+}
+
+// Renames the current file to <path>.1, shifting older files and removing the
+// ones beyond the maximum number of backups.
+// Must be called with the lock held.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("error closing audit log: %w", err)
+	}
+	if l.maxBackups > 0 {
+		os.Remove(l.backupPath(l.maxBackups))
+		for i := l.maxBackups - 1; i >= 1; i-- {
+			os.Rename(l.backupPath(i), l.backupPath(i+1))
+		}
+		if err := os.Rename(l.path, l.backupPath(1)); err != nil {
+			return fmt.Errorf("error rotating audit log: %w", err)
+		}
+	} else if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("error rotating audit log: %w", err)
+	}
+	return l.open()
+}
+
+func (l *Log) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", l.path, i)
+}
@@ -3,19 +3,29 @@ package config
 import (
 	"fmt"
 	"log/slog"
-	"strings"
 	"time"
 )
 
 type Config struct {
 	Proxy                 Proxy
 	Nameserver            Nameserver
-	ServiceDomain         string
+	ServiceDomains        []DomainRule
 	LogLevel              slog.Level
 	MainLoopTimeout       time.Duration
 	ReconciliationTimeout time.Duration
 	ReconcilerLoopTimeout time.Duration
 	Prometheus            Prometheus
+	Discovery             Discovery
+	Retry                 Retry
+	Deletion              Deletion
+	State                 State
+	LeaderElection        LeaderElection
+	Health                Health
+	Admin                 Admin
+	Notifications         Notifications
+	Audit                 Audit
+	Tracing               Tracing
+	Vault                 Vault
 }
 
 // Proxy
@@ -28,23 +38,70 @@ const (
 )
 
 type Proxy struct {
-	Type         ProxyType
+	// A source named after each type is configured with Fabio or Traefik.
+	Types        []ProxyType
 	PollInterval time.Duration
 	Fabio        FabioConf
 	Traefik      TraefikConf
+	// Additional sources, eg. a second Fabio cluster.
+	Instances []ProxyInstance
 }
 
 type FabioConf struct {
-	Hosts     []string
-	AdminPort uint16
-	Scheme    string
+	Hosts        []string
+	AdminPort    uint16
+	Scheme       string
+	Discovery    DiscoveryConf
+	Priority     int
+	HostTemplate HostTemplateConf
 }
 
 type TraefikConf struct {
-	Hosts       []string
-	AdminPort   uint16
-	Scheme      string
-	EntryPoints []string
+	Hosts        []string
+	AdminPort    uint16
+	Scheme       string
+	EntryPoints  []string
+	Discovery    DiscoveryConf
+	Priority     int
+	HostTemplate HostTemplateConf
+}
+
+// Generation of domains for routes that don't declare a host.
+type HostTemplateConf struct {
+	Enabled bool
+	// Go template, with the sanitized service name as .Service and the first
+	// service domain as .ServiceDomain.
+	Template string
+}
+
+// Proxy host discovery
+
+type DiscoveryType = string
+
+const (
+	StaticDiscovery DiscoveryType = "static"
+	ConsulDiscovery DiscoveryType = "consul"
+	DNSDiscovery    DiscoveryType = "dns"
+	NomadDiscovery  DiscoveryType = "nomad"
+)
+
+type DiscoveryConf struct {
+	Type DiscoveryType
+	// Consul or Nomad service name, or DNS SRV record name.
+	Name string
+	// Appended to Consul and Nomad node names to build proxy host names.
+	HostSuffix string
+}
+
+type Discovery struct {
+	Interval time.Duration
+	// Timeout of requests to Consul and Nomad.
+	Timeout    time.Duration
+	ConsulAddr string
+	NomadAddr  string
+	// ACL tokens, none are sent if empty.
+	ConsulToken Redacted
+	NomadToken  Redacted
 }
 
 // Nameserver
@@ -57,21 +114,156 @@ const (
 )
 
 type Nameserver struct {
-	Type         NameserverType
+	// A backend named after each type is configured with Pihole or Route53.
+	Types        []NameserverType
 	PollInterval time.Duration
-	Pihole       PiholeConf
-	Route53      Route53Conf
+	// Delete records of other types than CNAME holding a served domain's name,
+	// so that its record can be created. Otherwise, only records bingo created
+	// are replaced.
+	ReplaceConflicting bool
+	Pihole             PiholeConf
+	Route53            Route53Conf
+	// Additional backends, eg. a second Pi-hole or another hosted zone.
+	Instances []NameserverInstance
 }
 
 type PiholeConf struct {
 	URL      string
-	Password string
+	Password Redacted
+	// Overrides Nameserver.PollInterval when set.
+	PollInterval time.Duration
 }
 
 type Route53Conf struct {
 	HostedZone string
 	TTL        int64
 	AWSRegion  string
+	// The AWS SDK's default credentials are used if empty.
+	AccessKeyID     Redacted
+	SecretAccessKey Redacted
+	// Only set for temporary credentials.
+	SessionToken Redacted
+	// Overrides Nameserver.PollInterval when set.
+	PollInterval time.Duration
+}
+
+// Deletion of records whose domain vanished from the proxies
+
+// A record is deleted once its domain has been absent from the proxies for the
+// grace period, or for the number of consecutive polls, whichever comes first.
+// Records are deleted as soon as their domain vanishes if both are zero.
+type Deletion struct {
+	GracePeriod time.Duration
+	GracePolls  int
+	// Deletions are refused when a reconciliation would delete more records
+	// than this, eg. when a proxy returns an empty route table. Never if zero.
+	MaxRecords int
+}
+
+// Failed record changes
+
+type Retry struct {
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Number of failures after which a change is not attempted anymore, never if zero.
+	QuarantineAfter int
+}
+
+// Persistent state
+
+type State struct {
+	// Path of the state file, state isn't persisted if empty.
+	Path          string
+	FlushInterval time.Duration
+	// Only delete or modify records bingo created, according to the state file.
+	OwnedOnly bool
+}
+
+// Leader election
+
+type LeaderElectionType = string
+
+const (
+	NoLeaderElection         LeaderElectionType = ""
+	ConsulLeaderElection     LeaderElectionType = "consul"
+	KubernetesLeaderElection LeaderElectionType = "kubernetes"
+	FileLeaderElection       LeaderElectionType = "file"
+)
+
+type LeaderElection struct {
+	// Disabled if empty, every instance is then a leader.
+	Type LeaderElectionType
+	// Identifies this instance, the host name if empty.
+	Identity string
+	// How long leadership lasts without being renewed.
+	TTL time.Duration
+	// Time interval between attempts to acquire or renew leadership.
+	RetryInterval time.Duration
+	ConsulKey     string
+	LeaseName     string
+	// The namespace bingo runs in if empty.
+	LeaseNamespace string
+	LockPath       string
+}
+
+// Liveness and readiness
+
+type Health struct {
+	// Bingo isn't ready when proxy services or nameserver records weren't
+	// listed successfully for this long.
+	StaleAfter time.Duration
+	// Bingo isn't live when one of its loops didn't run for this long.
+	LivenessTimeout time.Duration
+}
+
+// Admin actions (dashboard buttons and admin API)
+
+type Admin struct {
+	// Bearer token required by admin actions, disabled if empty.
+	Token Redacted
+}
+
+// Change notifications
+
+type Notifications struct {
+	// Webhooks notified of record changes, notifications are disabled if empty.
+	WebhookURLs []Redacted
+	// Go template rendering the webhook body, the batch of changes is sent as
+	// JSON if empty.
+	Template   string
+	Timeout    time.Duration
+	Attempts   int
+	RetryDelay time.Duration
+}
+
+// Audit log
+
+type Audit struct {
+	// Path of the JSON Lines audit log, disabled if empty.
+	Path string
+	// Size in bytes past which the audit log is rotated, never if zero.
+	MaxSize int64
+	// Number of rotated files kept.
+	MaxBackups int
+}
+
+// Tracing
+
+type Tracing struct {
+	Enabled bool
+	// URL of the OTLP/HTTP endpoint, eg. "http://localhost:4318". The
+	// OTEL_EXPORTER_OTLP_* environment variables apply if empty.
+	Endpoint string
+	// Fraction of traces recorded, between 0 and 1.
+	SampleRatio float64
+}
+
+// Secrets lookup
+
+// Secrets set to "vault:<path>#<key>" are read from Vault's KV engine.
+type Vault struct {
+	Addr  string
+	Token Redacted
 }
 
 // Metrics
@@ -81,15 +273,74 @@ type Prometheus struct {
 	MetricsPath string
 }
 
-func (c *Config) IsServiceDomain(domain string) bool {
-	return strings.HasSuffix(domain, c.ServiceDomain)
-}
-
 func (c *Config) Validate() error {
-	if c.Proxy.Type == Fabio {
-		if len(c.Proxy.Fabio.Hosts) == 0 {
-			return fmt.Errorf("there must be at least one Fabio host in the config")
+	if len(c.ServiceDomains) == 0 {
+		return fmt.Errorf("there must be at least one service domain in the config")
+	}
+	for i := range c.ServiceDomains {
+		if err := c.ServiceDomains[i].Validate(); err != nil {
+			return err
 		}
 	}
+	if len(c.Nameserver.Backends()) == 0 {
+		return fmt.Errorf("there must be at least one nameserver in the config")
+	}
+	if err := validateNames("nameserver", c.Nameserver.BackendNames()); err != nil {
+		return err
+	}
+	for _, backend := range c.Nameserver.Backends() {
+		if err := backend.validate(); err != nil {
+			return err
+		}
+	}
+	if len(c.Proxy.Sources()) == 0 {
+		return fmt.Errorf("there must be at least one proxy in the config")
+	}
+	if err := validateNames("proxy", c.Proxy.SourceNames()); err != nil {
+		return err
+	}
+	for _, source := range c.Proxy.Sources() {
+		if err := source.validate(); err != nil {
+			return err
+		}
+	}
+	for i := range c.ServiceDomains {
+		if err := c.ServiceDomains[i].validateBackends(c.Nameserver.BackendNames(), c.Proxy.SourceNames()); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.Proxy.SourceNames() {
+		if c.ProxyServiceDomain(name) == "" {
+			return fmt.Errorf("no service domain is provided by the %s proxy", name)
+		}
+	}
+	switch c.LeaderElection.Type {
+	case NoLeaderElection:
+	case ConsulLeaderElection, KubernetesLeaderElection:
+		if c.LeaderElection.RetryInterval >= c.LeaderElection.TTL {
+			return fmt.Errorf("the leader election retry interval must be shorter than its TTL")
+		}
+	case FileLeaderElection:
+		if c.LeaderElection.LockPath == "" {
+			return fmt.Errorf("a lock path is required for file leader election")
+		}
+	default:
+		return fmt.Errorf("unknown leader election type \"%s\"", c.LeaderElection.Type)
+	}
+	if c.Deletion.GracePeriod < 0 || c.Deletion.GracePolls < 0 {
+		return fmt.Errorf("the deletion grace period and polls can't be negative")
+	}
+	if c.Deletion.MaxRecords < 0 {
+		return fmt.Errorf("the maximum number of deleted records can't be negative")
+	}
+	if len(c.Notifications.WebhookURLs) > 0 && c.Notifications.Attempts < 1 {
+		return fmt.Errorf("there must be at least one notification attempt")
+	}
+	if c.Audit.MaxSize < 0 || c.Audit.MaxBackups < 0 {
+		return fmt.Errorf("the audit log maximum size and backups can't be negative")
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		return fmt.Errorf("the tracing sample ratio must be between 0 and 1")
+	}
 	return nil
 }
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/n6g7/bingo/internal/dnsname"
+	"golang.org/x/exp/slices"
+)
+
+type TargetPolicy = string
+
+const (
+	// Pick any proxy host serving the domain.
+	RandomTarget TargetPolicy = "random"
+	// Always pick the same proxy host for a given domain, as long as the set of
+	// hosts serving it doesn't change.
+	HashTarget TargetPolicy = "hash"
+)
+
+// The rules applying to a managed service domain and its subdomains.
+type DomainRule struct {
+	Domain string
+	// Names of the nameserver backends managing records for this domain, all of
+	// them if empty.
+	Nameservers []string
+	// Names of the proxy sources providing services for this domain, all of
+	// them if empty.
+	Proxies []string
+	// TTL of created records, the nameserver default if zero.
+	TTL int64
+	// How to pick a proxy host among the ones serving a domain, random if empty.
+	TargetPolicy TargetPolicy
+	// Only manage subdomains matching one of these patterns, all of them if empty.
+	Include []string
+	// Never manage subdomains matching one of these patterns.
+	Exclude []string
+	// Maximum number of labels below the service domain, unlimited if zero.
+	MaxDepth int
+
+	include []*domainPattern
+	exclude []*domainPattern
+}
+
+// A glob (eg. "*.infra.svc.local") or, when wrapped in slashes, a regular
+// expression (eg. "/^[a-z]+\.svc\.local$/") matched against whole domains.
+type domainPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func compilePattern(pattern string) (*domainPattern, error) {
+	pattern = strings.ToLower(pattern)
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return &domainPattern{re: re}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return &domainPattern{glob: pattern}, nil
+}
+
+func (p *domainPattern) match(domain string) bool {
+	if p.re != nil {
+		return p.re.MatchString(domain)
+	}
+	matched, _ := path.Match(p.glob, domain)
+	return matched
+}
+
+func matchAny(patterns []*domainPattern, domain string) bool {
+	for _, pattern := range patterns {
+		if pattern.match(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses a domain rule from its environment variable representation:
+// the domain optionally followed by semicolon-separated options, list values
+// being separated by "+". For example:
+//
+//	svc.local;nameservers=pihole+route53;proxies=fabio;ttl=300;target=hash;exclude=*.infra.svc.local;depth=1
+func (d *DomainRule) UnmarshalText(text []byte) error {
+	parts := strings.Split(strings.TrimSpace(string(text)), ";")
+	d.Domain = parts[0]
+	d.TargetPolicy = RandomTarget
+
+	for _, part := range parts[1:] {
+		key, value, found := strings.Cut(part, "=")
+		if !found {
+			return fmt.Errorf("invalid option \"%s\" for domain \"%s\"", part, d.Domain)
+		}
+		switch key {
+		case "nameservers":
+			d.Nameservers = strings.Split(value, "+")
+		case "proxies":
+			d.Proxies = strings.Split(value, "+")
+		case "ttl":
+			ttl, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid TTL for domain \"%s\": %w", d.Domain, err)
+			}
+			d.TTL = ttl
+		case "target":
+			d.TargetPolicy = value
+		case "include":
+			d.Include = strings.Split(value, "+")
+		case "exclude":
+			d.Exclude = strings.Split(value, "+")
+		case "depth":
+			depth, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid depth for domain \"%s\": %w", d.Domain, err)
+			}
+			d.MaxDepth = depth
+		default:
+			return fmt.Errorf("unknown option \"%s\" for domain \"%s\"", key, d.Domain)
+		}
+	}
+	return nil
+}
+
+// Returns whether the named nameserver backend manages records for this domain.
+func (d *DomainRule) HasNameserver(name string) bool {
+	return len(d.Nameservers) == 0 || slices.Contains(d.Nameservers, name)
+}
+
+// Returns whether the named proxy source provides services for this domain.
+func (d *DomainRule) HasProxy(name string) bool {
+	return len(d.Proxies) == 0 || slices.Contains(d.Proxies, name)
+}
+
+// Validates the rule and compiles its patterns.
+func (d *DomainRule) Validate() error {
+	domain, err := dnsname.Normalize(d.Domain)
+	if err != nil {
+		return fmt.Errorf("invalid service domain: %w", err)
+	}
+	d.Domain = domain
+	if d.MaxDepth < 0 {
+		return fmt.Errorf("invalid depth %d for domain \"%s\"", d.MaxDepth, d.Domain)
+	}
+
+	d.include = nil
+	for _, pattern := range d.Include {
+		compiled, err := compilePattern(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid include pattern \"%s\" for domain \"%s\": %w", pattern, d.Domain, err)
+		}
+		d.include = append(d.include, compiled)
+	}
+	d.exclude = nil
+	for _, pattern := range d.Exclude {
+		compiled, err := compilePattern(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid exclude pattern \"%s\" for domain \"%s\": %w", pattern, d.Domain, err)
+		}
+		d.exclude = append(d.exclude, compiled)
+	}
+
+	switch d.TargetPolicy {
+	case "", RandomTarget, HashTarget:
+	default:
+		return fmt.Errorf("unknown target policy \"%s\" for domain \"%s\"", d.TargetPolicy, d.Domain)
+	}
+	return nil
+}
+
+// Checks the rule only refers to configured nameservers and proxies, a typo
+// would leave the domain unmanaged.
+func (d *DomainRule) validateBackends(nameservers, proxies []string) error {
+	for _, name := range d.Nameservers {
+		if !slices.Contains(nameservers, name) {
+			return fmt.Errorf("unknown or unconfigured nameserver \"%s\" for domain \"%s\"", name, d.Domain)
+		}
+	}
+	for _, name := range d.Proxies {
+		if !slices.Contains(proxies, name) {
+			return fmt.Errorf("unknown or unconfigured proxy \"%s\" for domain \"%s\"", name, d.Domain)
+		}
+	}
+	return nil
+}
+
+// Returns the number of labels of domain below the parent domain, or 0 if
+// domain isn't a strict subdomain of parent. Both must be normalized.
+func subdomainDepth(domain, parent string) int {
+	if !strings.HasSuffix(domain, "."+parent) {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(domain, "."+parent), ".") + 1
+}
+
+// Returns the first service domain the named proxy source provides services
+// for, which domains generated from its service names are under.
+func (c *Config) ProxyServiceDomain(name string) string {
+	for _, rule := range c.ServiceDomains {
+		if rule.HasProxy(name) {
+			return rule.Domain
+		}
+	}
+	return ""
+}
+
+// Returns the rule set for the most specific service domain the domain belongs
+// to, or nil if bingo must not manage the domain.
+// This is the single place deciding which domains are managed: the domain must
+// be a strict subdomain of a service domain (label-wise, the service domain
+// itself is never managed), match the rule's include patterns if any, none of
+// its exclude patterns, and not be nested deeper than its maximum depth.
+func (c *Config) DomainRule(domain string) *DomainRule {
+	domain, err := dnsname.Normalize(domain)
+	if err != nil {
+		return nil
+	}
+
+	var match *DomainRule
+	depth := 0
+	for i, rule := range c.ServiceDomains {
+		ruleDepth := subdomainDepth(domain, rule.Domain)
+		if ruleDepth == 0 {
+			continue
+		}
+		if match == nil || len(rule.Domain) > len(match.Domain) {
+			match = &c.ServiceDomains[i]
+			depth = ruleDepth
+		}
+	}
+	if match == nil {
+		return nil
+	}
+
+	if match.MaxDepth > 0 && depth > match.MaxDepth {
+		return nil
+	}
+	if len(match.include) > 0 && !matchAny(match.include, domain) {
+		return nil
+	}
+	if matchAny(match.exclude, domain) {
+		return nil
+	}
+	return match
+}
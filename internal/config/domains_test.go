@@ -0,0 +1,133 @@
+package config
+
+import "testing"
+
+func TestSubdomainDepth(t *testing.T) {
+	tests := []struct {
+		domain string
+		parent string
+		want   int
+	}{
+		{"app.svc.local", "svc.local", 1},
+		{"api.app.svc.local", "svc.local", 2},
+		{"svc.local", "svc.local", 0},
+		{"notsvc.local", "svc.local", 0},
+		{"app.notsvc.local", "svc.local", 0},
+		{"app.svc.local.com", "svc.local", 0},
+	}
+	for _, tt := range tests {
+		if got := subdomainDepth(tt.domain, tt.parent); got != tt.want {
+			t.Errorf("subdomainDepth(%q, %q) = %d, want %d", tt.domain, tt.parent, got, tt.want)
+		}
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		domain  string
+		want    bool
+	}{
+		{"*.svc.local", "app.svc.local", true},
+		{"*.svc.local", "api.app.svc.local", true},
+		{"*.infra.svc.local", "app.svc.local", false},
+		{"app.svc.local", "app.svc.local", true},
+		{"APP.svc.local", "app.svc.local", true},
+		{"/^[a-z]+\\.svc\\.local$/", "app.svc.local", true},
+		{"/^[a-z]+\\.svc\\.local$/", "app2.svc.local", false},
+		{"/app/", "myapp.svc.local", true},
+	}
+	for _, tt := range tests {
+		pattern, err := compilePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("compilePattern(%q) failed: %v", tt.pattern, err)
+			continue
+		}
+		if got := pattern.match(tt.domain); got != tt.want {
+			t.Errorf("pattern %q matching %q = %t, want %t", tt.pattern, tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestCompilePatternInvalid(t *testing.T) {
+	for _, pattern := range []string{"[a-", "/[a-/"} {
+		if _, err := compilePattern(pattern); err == nil {
+			t.Errorf("compilePattern(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestDomainRule(t *testing.T) {
+	conf := &Config{}
+	for _, text := range []string{
+		"svc.local.;exclude=*.infra.svc.local",
+		"deep.svc.local;depth=1",
+		"web.example.com;include=/^[a-z]*\\.web\\.example\\.com$/",
+	} {
+		rule := DomainRule{}
+		if err := rule.UnmarshalText([]byte(text)); err != nil {
+			t.Fatalf("couldn't parse rule %q: %v", text, err)
+		}
+		if err := rule.Validate(); err != nil {
+			t.Fatalf("invalid rule %q: %v", text, err)
+		}
+		conf.ServiceDomains = append(conf.ServiceDomains, rule)
+	}
+
+	tests := []struct {
+		domain string
+		want   string // domain of the matching rule, empty if none
+	}{
+		{"app.svc.local", "svc.local"},
+		{"APP.svc.local.", "svc.local"},
+		{"api.app.svc.local", "svc.local"},
+		{"svc.local", ""},
+		{"notsvc.local", ""},
+		{"app.notsvc.local", ""},
+		{"db.infra.svc.local", ""},
+		{"app.deep.svc.local", "deep.svc.local"},
+		{"api.app.deep.svc.local", ""},
+		{"deep.svc.local", "svc.local"},
+		{"app.web.example.com", "web.example.com"},
+		{"app2.web.example.com", ""},
+		{"invalid_name.svc.local", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if rule := conf.DomainRule(tt.domain); rule != nil {
+			got = rule.Domain
+		}
+		if got != tt.want {
+			t.Errorf("DomainRule(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestDomainRuleBackends(t *testing.T) {
+	nameservers := []NameserverType{Pihole}
+	proxies := []ProxyType{Fabio, Traefik}
+	tests := []struct {
+		text  string
+		valid bool
+	}{
+		{"svc.local", true},
+		{"svc.local;nameservers=pihole", true},
+		{"svc.local;proxies=fabio+traefik", true},
+		{"svc.local;nameservers=pihol", false},
+		{"svc.local;nameservers=pihole+route53", false},
+		{"svc.local;proxies=fabbio", false},
+		{"svc.local;proxies=", false},
+	}
+	for _, tt := range tests {
+		rule := DomainRule{}
+		if err := rule.UnmarshalText([]byte(tt.text)); err != nil {
+			t.Fatalf("couldn't parse rule %q: %v", tt.text, err)
+		}
+		err := rule.validateBackends(nameservers, proxies)
+		if tt.valid && err != nil {
+			t.Errorf("rule %q: unexpected error %v", tt.text, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("rule %q: want an error", tt.text)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// A named proxy source. Its name identifies it in service domain rules, logs
+// and metrics.
+type ProxyInstance struct {
+	Name    string
+	Type    ProxyType
+	Fabio   FabioConf
+	Traefik TraefikConf
+}
+
+func (p ProxyInstance) Priority() int {
+	if p.Type == Traefik {
+		return p.Traefik.Priority
+	}
+	return p.Fabio.Priority
+}
+
+func (p ProxyInstance) discovery() (DiscoveryConf, []string) {
+	if p.Type == Traefik {
+		return p.Traefik.Discovery, p.Traefik.Hosts
+	}
+	return p.Fabio.Discovery, p.Fabio.Hosts
+}
+
+// A named nameserver backend. Its name identifies it in service domain rules,
+// the state file, the admin API, logs and metrics.
+type NameserverInstance struct {
+	Name    string
+	Type    NameserverType
+	Pihole  PiholeConf
+	Route53 Route53Conf
+}
+
+// Returns every proxy source: one per type in Types, named after it and
+// configured with the top-level settings, then the named instances.
+func (p *Proxy) Sources() []ProxyInstance {
+	sources := []ProxyInstance{}
+	for _, proxyType := range p.Types {
+		sources = append(sources, ProxyInstance{Name: proxyType, Type: proxyType, Fabio: p.Fabio, Traefik: p.Traefik})
+	}
+	return append(sources, p.Instances...)
+}
+
+// Returns the proxy source with this name, nil if there is none.
+func (p *Proxy) Source(name string) *ProxyInstance {
+	for _, source := range p.Sources() {
+		if source.Name == name {
+			return &source
+		}
+	}
+	return nil
+}
+
+// Returns every nameserver backend: one per type in Types, named after it and
+// configured with the top-level settings, then the named instances.
+func (n *Nameserver) Backends() []NameserverInstance {
+	backends := []NameserverInstance{}
+	for _, nsType := range n.Types {
+		backends = append(backends, NameserverInstance{Name: nsType, Type: nsType, Pihole: n.Pihole, Route53: n.Route53})
+	}
+	return append(backends, n.Instances...)
+}
+
+// Returns the nameserver backend with this name, nil if there is none.
+func (n *Nameserver) Backend(name string) *NameserverInstance {
+	for _, backend := range n.Backends() {
+		if backend.Name == name {
+			return &backend
+		}
+	}
+	return nil
+}
+
+func names[T any](instances []T, name func(T) string) []string {
+	list := []string{}
+	for _, instance := range instances {
+		list = append(list, name(instance))
+	}
+	return list
+}
+
+func (p *Proxy) SourceNames() []string {
+	return names(p.Sources(), func(s ProxyInstance) string { return s.Name })
+}
+
+func (n *Nameserver) BackendNames() []string {
+	return names(n.Backends(), func(b NameserverInstance) string { return b.Name })
+}
+
+// Instance names end up in URLs and metric labels.
+var instanceName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func validateNames(kind string, list []string) error {
+	seen := map[string]bool{}
+	for _, name := range list {
+		if !instanceName.MatchString(name) {
+			return fmt.Errorf("invalid %s name \"%s\", only lowercase letters, digits, \"-\" and \"_\" are allowed", kind, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate %s name \"%s\"", kind, name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+func (p ProxyInstance) validate() error {
+	if p.Type != Fabio && p.Type != Traefik {
+		return fmt.Errorf("unknown proxy type \"%s\"", p.Type)
+	}
+	discovery, hosts := p.discovery()
+	switch discovery.Type {
+	case StaticDiscovery, ConsulDiscovery, DNSDiscovery, NomadDiscovery:
+	default:
+		return fmt.Errorf("unknown discovery type \"%s\" for the %s proxy", discovery.Type, p.Name)
+	}
+	if discovery.Type == StaticDiscovery && len(hosts) == 0 {
+		if p.Type == Traefik {
+			return fmt.Errorf("there must be at least one Traefik host in the config for the %s proxy", p.Name)
+		}
+		return fmt.Errorf("there must be at least one Fabio host in the config for the %s proxy", p.Name)
+	}
+	return nil
+}
+
+func (n NameserverInstance) validate() error {
+	switch n.Type {
+	case Pihole:
+	case Route53:
+		if (n.Route53.AccessKeyID == "") != (n.Route53.SecretAccessKey == "") {
+			return fmt.Errorf("both the AWS access key ID and secret access key of the %s nameserver must be set, or neither", n.Name)
+		}
+		if n.Route53.SessionToken != "" && n.Route53.AccessKeyID == "" {
+			return fmt.Errorf("the AWS session token of the %s nameserver requires an access key ID and secret access key", n.Name)
+		}
+	default:
+		return fmt.Errorf("unknown nameserver type \"%s\"", n.Type)
+	}
+	return nil
+}
+
+// Named instances get the defaults of top-level settings, viper only sets them
+// for the latter.
+func (c *Config) fillInstanceDefaults(defaults *Config) {
+	for i := range c.Proxy.Instances {
+		fillZero(&c.Proxy.Instances[i].Fabio, defaults.Proxy.Fabio)
+		fillZero(&c.Proxy.Instances[i].Traefik, defaults.Proxy.Traefik)
+	}
+	for i := range c.Nameserver.Instances {
+		fillZero(&c.Nameserver.Instances[i].Pihole, defaults.Nameserver.Pihole)
+		fillZero(&c.Nameserver.Instances[i].Route53, defaults.Nameserver.Route53)
+	}
+}
+
+// Sets the zero fields of dst, a pointer to a struct, to their value in
+// defaults, a struct of the same type. Nested structs are filled field by field.
+func fillZero(dst, defaults any) {
+	fillZeroValue(reflect.ValueOf(dst).Elem(), reflect.ValueOf(defaults))
+}
+
+func fillZeroValue(dst, defaults reflect.Value) {
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Field(i)
+		switch {
+		case !field.CanSet():
+		case field.Kind() == reflect.Struct:
+			fillZeroValue(field, defaults.Field(i))
+		case field.IsZero():
+			field.Set(defaults.Field(i))
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const instancesConfig = `
+serviceDomains:
+  - domain: svc.local
+    nameservers: [lan, dmz]
+  - domain: edge.lan
+    proxies: [edge]
+proxy:
+  types: [fabio]
+  fabio:
+    hosts: [fabio.lan]
+  instances:
+    - name: edge
+      type: fabio
+      fabio:
+        hosts: [edge.lan]
+        adminPort: 9999
+        priority: 10
+nameserver:
+  types: []
+  instances:
+    - name: lan
+      type: pihole
+      pihole:
+        url: http://pihole.lan
+    - name: dmz
+      type: route53
+      route53:
+        hostedZone: Z123
+        ttl: 60
+`
+
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	path := filepath.Join(t.TempDir(), "bingo.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestInstances(t *testing.T) {
+	conf, err := loadTestConfig(t, instancesConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := conf.Proxy.SourceNames(); !reflect.DeepEqual(names, []string{"fabio", "edge"}) {
+		t.Errorf("proxy sources = %v", names)
+	}
+	if names := conf.Nameserver.BackendNames(); !reflect.DeepEqual(names, []string{"lan", "dmz"}) {
+		t.Errorf("nameserver backends = %v", names)
+	}
+
+	// Settings left out get the defaults of the top-level ones
+	edge := conf.Proxy.Source("edge")
+	if edge.Fabio.AdminPort != 9999 || edge.Fabio.Scheme != "http" || edge.Fabio.Discovery.Type != StaticDiscovery || edge.Fabio.HostTemplate.Template == "" {
+		t.Errorf("edge proxy settings = %+v", edge.Fabio)
+	}
+	if edge.Priority() != 10 {
+		t.Errorf("edge proxy priority = %d, want 10", edge.Priority())
+	}
+	dmz := conf.Nameserver.Backend("dmz")
+	if dmz.Route53.TTL != 60 || dmz.Route53.AWSRegion != "us-west-1" {
+		t.Errorf("dmz nameserver settings = %+v", dmz.Route53)
+	}
+
+	if domain := conf.ProxyServiceDomain("fabio"); domain != "svc.local" {
+		t.Errorf("fabio service domain = %q, want svc.local", domain)
+	}
+	if domain := conf.ProxyServiceDomain("edge"); domain != "svc.local" {
+		t.Errorf("edge service domain = %q, want svc.local", domain)
+	}
+	if rule := conf.DomainRule("app.edge.lan"); rule.HasProxy("fabio") || !rule.HasProxy("edge") || !rule.HasNameserver("dmz") {
+		t.Errorf("edge.lan rule = %+v", rule)
+	}
+}
+
+func TestInstancesValidation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		valid  bool
+	}{
+		{"valid", func(c *Config) {}, true},
+		{"duplicate nameserver", func(c *Config) { c.Nameserver.Instances[1].Name = "lan" }, false},
+		{"nameserver named after a type", func(c *Config) { c.Nameserver.Types = []NameserverType{Pihole, Route53} }, true},
+		{"nameserver clashing with a type", func(c *Config) {
+			c.Nameserver.Types = []NameserverType{Pihole}
+			c.Nameserver.Instances[0].Name = "pihole"
+		}, false},
+		{"invalid nameserver name", func(c *Config) { c.Nameserver.Instances[0].Name = "Pi hole" }, false},
+		{"unknown nameserver type", func(c *Config) { c.Nameserver.Instances[0].Type = "bind" }, false},
+		{"no nameserver", func(c *Config) { c.Nameserver.Instances = nil }, false},
+		{"partial AWS keys", func(c *Config) { c.Nameserver.Instances[1].Route53.AccessKeyID = "AKIA" }, false},
+		{"duplicate proxy", func(c *Config) { c.Proxy.Instances[0].Name = "fabio" }, false},
+		{"unknown proxy type", func(c *Config) { c.Proxy.Instances[0].Type = "nginx" }, false},
+		{"proxy without hosts", func(c *Config) { c.Proxy.Instances[0].Fabio.Hosts = nil }, false},
+		{"rule naming a removed instance", func(c *Config) { c.Proxy.Instances = nil }, false},
+	}
+	for _, tt := range tests {
+		conf, err := loadTestConfig(t, instancesConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.modify(conf)
+		err = conf.Validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		} else if !tt.valid && err == nil {
+			t.Errorf("%s: want an error", tt.name)
+		}
+	}
+}
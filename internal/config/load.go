@@ -3,64 +3,241 @@ package config
 import (
 	"fmt"
 	"log/slog"
+	"maps"
+	"path/filepath"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
-func Load() (*Config, error) {
-	viper.SetDefault("Proxy.Type", Fabio)
-	viper.SetDefault("Proxy.PollInterval", 5*time.Second)
-	viper.SetDefault("Proxy.Fabio.AdminPort", "9998")
-	viper.SetDefault("Proxy.Fabio.Scheme", "http")
-	viper.SetDefault("Proxy.Traefik.AdminPort", "8080")
-	viper.SetDefault("Proxy.Traefik.Scheme", "http")
-	viper.SetDefault("Nameserver.Type", Pihole)
-	viper.SetDefault("Nameserver.PollInterval", 30*time.Second)
-	viper.SetDefault("Nameserver.Route53.TTL", 3600)
-	viper.SetDefault("Nameserver.Route53.AWSRegion", "us-west-1")
-	viper.SetDefault("LogLevel", slog.LevelInfo)
-	viper.SetDefault("MainLoopTimeout", 1*time.Second)
-	viper.SetDefault("ReconciliationTimeout", 30*time.Second)
-	viper.SetDefault("ReconcilerLoopTimeout", 1*time.Second)
-	viper.SetDefault("Prometheus.ListenAddr", ":9100")
-	viper.SetDefault("Prometheus.MetricsPath", "/metrics")
+// Loads the config from the environment and, if path isn't empty, from a YAML,
+// TOML or HCL file. Environment variables override values from the file.
+// Loading again reloads the file from scratch.
+func Load(path string) (*Config, error) {
+	v := viper.New()
+	setDefaults(v)
 
-	viper.BindEnv("Proxy.Type", "PROXY_TYPE")
-	viper.BindEnv("Proxy.PollInterval", "PROXY_POLL_INTERVAL")
-	viper.BindEnv("Proxy.Fabio.Hosts", "FABIO_HOSTS")
-	viper.BindEnv("Proxy.Fabio.AdminPort", "FABIO_ADMIN_PORT")
-	viper.BindEnv("Proxy.Fabio.Scheme", "FABIO_SCHEME")
-	viper.BindEnv("Proxy.Traefik.Hosts", "TRAEFIK_HOSTS")
-	viper.BindEnv("Proxy.Traefik.AdminPort", "TRAEFIK_ADMIN_PORT")
-	viper.BindEnv("Proxy.Traefik.Scheme", "TRAEFIK_SCHEME")
-	viper.BindEnv("Proxy.Traefik.EntryPoints", "TRAEFIK_ENTRYPOINTS")
-	viper.BindEnv("Nameserver.Type", "NAMESERVER_TYPE")
-	viper.BindEnv("Nameserver.PollInterval", "NAMESERVER_POLL_INTERVAL")
-	viper.BindEnv("Nameserver.Pihole.URL", "PIHOLE_URL")
-	viper.BindEnv("Nameserver.Pihole.Password", "PIHOLE_PASSWORD")
-	viper.BindEnv("Nameserver.Route53.HostedZone", "ROUTE53_HOSTED_ZONE")
-	viper.BindEnv("Nameserver.Route53.TTL", "ROUTE53_TTL")
-	viper.BindEnv("Nameserver.Route53.AWSRegion", "AWS_REGION")
-	viper.BindEnv("ServiceDomain", "SERVICE_DOMAIN")
-	viper.BindEnv("LogLevel", "LOG_LEVEL")
-	viper.BindEnv("MainLoopTimeout", "MAIN_LOOP_TIMEOUT")
-	viper.BindEnv("ReconciliationTimeout", "RECONCILIATION_TIMEOUT")
-	viper.BindEnv("ReconcilerLoopTimeout", "RECONCILER_LOOP_TIMEOUT")
-	viper.BindEnv("Prometheus.ListenAddr", "PROMETHEUS_LISTEN_ADDR")
-	viper.BindEnv("Prometheus.MetricsPath", "PROMETHEUS_METRICS_PATH")
+	v.BindEnv("Proxy.Types", "PROXY_TYPE")
+	v.BindEnv("Proxy.PollInterval", "PROXY_POLL_INTERVAL")
+	v.BindEnv("Proxy.Fabio.Hosts", "FABIO_HOSTS")
+	v.BindEnv("Proxy.Fabio.AdminPort", "FABIO_ADMIN_PORT")
+	v.BindEnv("Proxy.Fabio.Scheme", "FABIO_SCHEME")
+	v.BindEnv("Proxy.Fabio.Priority", "FABIO_PRIORITY")
+	v.BindEnv("Proxy.Fabio.HostTemplate.Enabled", "FABIO_GENERATE_HOSTS")
+	v.BindEnv("Proxy.Fabio.HostTemplate.Template", "FABIO_HOST_TEMPLATE")
+	v.BindEnv("Proxy.Traefik.Hosts", "TRAEFIK_HOSTS")
+	v.BindEnv("Proxy.Traefik.AdminPort", "TRAEFIK_ADMIN_PORT")
+	v.BindEnv("Proxy.Traefik.Scheme", "TRAEFIK_SCHEME")
+	v.BindEnv("Proxy.Traefik.Priority", "TRAEFIK_PRIORITY")
+	v.BindEnv("Proxy.Traefik.HostTemplate.Enabled", "TRAEFIK_GENERATE_HOSTS")
+	v.BindEnv("Proxy.Traefik.HostTemplate.Template", "TRAEFIK_HOST_TEMPLATE")
+	v.BindEnv("Proxy.Traefik.EntryPoints", "TRAEFIK_ENTRYPOINTS")
+	v.BindEnv("Proxy.Fabio.Discovery.Type", "FABIO_DISCOVERY")
+	v.BindEnv("Proxy.Fabio.Discovery.Name", "FABIO_DISCOVERY_NAME")
+	v.BindEnv("Proxy.Fabio.Discovery.HostSuffix", "FABIO_DISCOVERY_HOST_SUFFIX")
+	v.BindEnv("Proxy.Traefik.Discovery.Type", "TRAEFIK_DISCOVERY")
+	v.BindEnv("Proxy.Traefik.Discovery.Name", "TRAEFIK_DISCOVERY_NAME")
+	v.BindEnv("Proxy.Traefik.Discovery.HostSuffix", "TRAEFIK_DISCOVERY_HOST_SUFFIX")
+	v.BindEnv("Discovery.Interval", "DISCOVERY_INTERVAL")
+	v.BindEnv("Discovery.Timeout", "DISCOVERY_TIMEOUT")
+	v.BindEnv("Discovery.ConsulAddr", "CONSUL_HTTP_ADDR")
+	v.BindEnv("Discovery.ConsulToken", "CONSUL_HTTP_TOKEN")
+	v.BindEnv("Discovery.NomadAddr", "NOMAD_ADDR")
+	v.BindEnv("Discovery.NomadToken", "NOMAD_TOKEN")
+	v.BindEnv("Nameserver.Types", "NAMESERVER_TYPE")
+	v.BindEnv("Nameserver.PollInterval", "NAMESERVER_POLL_INTERVAL")
+	v.BindEnv("Nameserver.ReplaceConflicting", "REPLACE_CONFLICTING_RECORDS")
+	v.BindEnv("Nameserver.Pihole.URL", "PIHOLE_URL")
+	v.BindEnv("Nameserver.Pihole.Password", "PIHOLE_PASSWORD")
+	v.BindEnv("Nameserver.Pihole.PollInterval", "PIHOLE_POLL_INTERVAL")
+	v.BindEnv("Nameserver.Route53.HostedZone", "ROUTE53_HOSTED_ZONE")
+	v.BindEnv("Nameserver.Route53.TTL", "ROUTE53_TTL")
+	v.BindEnv("Nameserver.Route53.AWSRegion", "AWS_REGION")
+	v.BindEnv("Nameserver.Route53.AccessKeyID", "AWS_ACCESS_KEY_ID")
+	v.BindEnv("Nameserver.Route53.SecretAccessKey", "AWS_SECRET_ACCESS_KEY")
+	v.BindEnv("Nameserver.Route53.SessionToken", "AWS_SESSION_TOKEN")
+	v.BindEnv("Nameserver.Route53.PollInterval", "ROUTE53_POLL_INTERVAL")
+	v.BindEnv("ServiceDomains", "SERVICE_DOMAIN")
+	v.BindEnv("LogLevel", "LOG_LEVEL")
+	v.BindEnv("MainLoopTimeout", "MAIN_LOOP_TIMEOUT")
+	v.BindEnv("ReconciliationTimeout", "RECONCILIATION_TIMEOUT")
+	v.BindEnv("ReconcilerLoopTimeout", "RECONCILER_LOOP_TIMEOUT")
+	v.BindEnv("Retry.BaseDelay", "RETRY_BASE_DELAY")
+	v.BindEnv("Retry.MaxDelay", "RETRY_MAX_DELAY")
+	v.BindEnv("Retry.QuarantineAfter", "QUARANTINE_AFTER")
+	v.BindEnv("Deletion.GracePeriod", "DELETION_GRACE_PERIOD")
+	v.BindEnv("Deletion.GracePolls", "DELETION_GRACE_POLLS")
+	v.BindEnv("Deletion.MaxRecords", "DELETION_MAX_RECORDS")
+	v.BindEnv("State.Path", "STATE_PATH")
+	v.BindEnv("State.FlushInterval", "STATE_FLUSH_INTERVAL")
+	v.BindEnv("State.OwnedOnly", "STATE_OWNED_ONLY")
+	v.BindEnv("LeaderElection.Type", "LEADER_ELECTION")
+	v.BindEnv("LeaderElection.Identity", "LEADER_ELECTION_IDENTITY")
+	v.BindEnv("LeaderElection.TTL", "LEADER_ELECTION_TTL")
+	v.BindEnv("LeaderElection.RetryInterval", "LEADER_ELECTION_RETRY_INTERVAL")
+	v.BindEnv("LeaderElection.ConsulKey", "LEADER_ELECTION_CONSUL_KEY")
+	v.BindEnv("LeaderElection.LeaseName", "LEADER_ELECTION_LEASE_NAME")
+	v.BindEnv("LeaderElection.LeaseNamespace", "LEADER_ELECTION_LEASE_NAMESPACE")
+	v.BindEnv("LeaderElection.LockPath", "LEADER_ELECTION_LOCK_PATH")
+	v.BindEnv("Health.StaleAfter", "HEALTH_STALE_AFTER")
+	v.BindEnv("Health.LivenessTimeout", "HEALTH_LIVENESS_TIMEOUT")
+	v.BindEnv("Admin.Token", "ADMIN_TOKEN")
+	v.BindEnv("Vault.Addr", "VAULT_ADDR")
+	v.BindEnv("Vault.Token", "VAULT_TOKEN")
+	v.BindEnv("Notifications.WebhookURLs", "NOTIFY_WEBHOOK_URLS")
+	v.BindEnv("Notifications.Template", "NOTIFY_WEBHOOK_TEMPLATE")
+	v.BindEnv("Notifications.Timeout", "NOTIFY_TIMEOUT")
+	v.BindEnv("Notifications.Attempts", "NOTIFY_ATTEMPTS")
+	v.BindEnv("Notifications.RetryDelay", "NOTIFY_RETRY_DELAY")
+	v.BindEnv("Audit.Path", "AUDIT_LOG_PATH")
+	v.BindEnv("Audit.MaxSize", "AUDIT_LOG_MAX_SIZE")
+	v.BindEnv("Audit.MaxBackups", "AUDIT_LOG_MAX_BACKUPS")
+	v.BindEnv("Tracing.Enabled", "TRACING_ENABLED")
+	v.BindEnv("Tracing.Endpoint", "TRACING_ENDPOINT")
+	v.BindEnv("Tracing.SampleRatio", "TRACING_SAMPLE_RATIO")
+	v.BindEnv("Prometheus.ListenAddr", "PROMETHEUS_LISTEN_ADDR")
+	v.BindEnv("Prometheus.MetricsPath", "PROMETHEUS_METRICS_PATH")
+
+	if path != "" {
+		if err := readFile(v, path); err != nil {
+			return nil, err
+		}
+	}
 
 	config := &Config{}
-	err := viper.Unmarshal(config, viper.DecodeHook(
-		mapstructure.ComposeDecodeHookFunc(
-			mapstructure.StringToSliceHookFunc(","),
-			mapstructure.TextUnmarshallerHookFunc(),
-		),
-	))
-	if err != nil {
+	// Unknown keys are rejected, so that typos in the config file don't go unnoticed
+	if err := v.UnmarshalExact(config, decodeHook); err != nil {
 		return nil, fmt.Errorf("couldn't parse config: %w", err)
 	}
+	defaults := &Config{}
+	d := viper.New()
+	setDefaults(d)
+	if err := d.Unmarshal(defaults, decodeHook); err != nil {
+		return nil, fmt.Errorf("couldn't parse config defaults: %w", err)
+	}
+	config.fillInstanceDefaults(defaults)
+
+	secrets := config.secrets()
+	err := readSecretFiles(map[string]*Redacted{"VAULT_TOKEN": &config.Vault.Token})
+	if err != nil {
+		return nil, err
+	}
+	if err := readSecretFiles(secrets); err != nil {
+		return nil, err
+	}
+	if err := readSecretListFiles(config.secretLists()); err != nil {
+		return nil, err
+	}
+	for variable, list := range config.secretLists() {
+		for i := range *list {
+			secrets[fmt.Sprintf("%s[%d]", variable, i)] = &(*list)[i]
+		}
+	}
+	maps.Copy(secrets, config.instanceSecrets())
+	if err := resolveVaultSecrets(config.Vault, secrets); err != nil {
+		return nil, err
+	}
 
 	return config, config.Validate()
 }
+
+var decodeHook = viper.DecodeHook(
+	mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mapstructure.TextUnmarshallerHookFunc(),
+	),
+)
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("Proxy.Types", []ProxyType{Fabio})
+	v.SetDefault("Proxy.PollInterval", 5*time.Second)
+	v.SetDefault("Proxy.Fabio.AdminPort", "9998")
+	v.SetDefault("Proxy.Fabio.Scheme", "http")
+	v.SetDefault("Proxy.Traefik.AdminPort", "8080")
+	v.SetDefault("Proxy.Traefik.Scheme", "http")
+	v.SetDefault("Proxy.Fabio.HostTemplate.Template", "{{.Service}}.{{.ServiceDomain}}")
+	v.SetDefault("Proxy.Traefik.HostTemplate.Template", "{{.Service}}.{{.ServiceDomain}}")
+	v.SetDefault("Proxy.Fabio.Discovery.Type", StaticDiscovery)
+	v.SetDefault("Proxy.Traefik.Discovery.Type", StaticDiscovery)
+	v.SetDefault("Discovery.Interval", 30*time.Second)
+	v.SetDefault("Discovery.Timeout", 10*time.Second)
+	v.SetDefault("Discovery.ConsulAddr", "http://127.0.0.1:8500")
+	v.SetDefault("Discovery.NomadAddr", "http://127.0.0.1:4646")
+	v.SetDefault("Nameserver.Types", []NameserverType{Pihole})
+	v.SetDefault("Nameserver.PollInterval", 30*time.Second)
+	v.SetDefault("Nameserver.Route53.TTL", 3600)
+	v.SetDefault("Nameserver.Route53.AWSRegion", "us-west-1")
+	v.SetDefault("LogLevel", slog.LevelInfo)
+	v.SetDefault("MainLoopTimeout", 1*time.Second)
+	v.SetDefault("ReconciliationTimeout", 30*time.Second)
+	v.SetDefault("ReconcilerLoopTimeout", 1*time.Second)
+	v.SetDefault("Retry.BaseDelay", 30*time.Second)
+	v.SetDefault("Retry.MaxDelay", 30*time.Minute)
+	v.SetDefault("Retry.QuarantineAfter", 10)
+	v.SetDefault("Deletion.GracePeriod", 0)
+	v.SetDefault("Deletion.GracePolls", 0)
+	v.SetDefault("Deletion.MaxRecords", 0)
+	v.SetDefault("State.FlushInterval", 1*time.Minute)
+	v.SetDefault("Health.StaleAfter", 5*time.Minute)
+	v.SetDefault("Health.LivenessTimeout", 5*time.Minute)
+	v.SetDefault("LeaderElection.TTL", 15*time.Second)
+	v.SetDefault("LeaderElection.RetryInterval", 5*time.Second)
+	v.SetDefault("LeaderElection.ConsulKey", "service/bingo/leader")
+	v.SetDefault("LeaderElection.LeaseName", "bingo")
+	v.SetDefault("Notifications.Timeout", 10*time.Second)
+	v.SetDefault("Notifications.Attempts", 5)
+	v.SetDefault("Notifications.RetryDelay", 5*time.Second)
+	v.SetDefault("Audit.MaxSize", 10*1024*1024)
+	v.SetDefault("Audit.MaxBackups", 5)
+	v.SetDefault("Tracing.Enabled", false)
+	v.SetDefault("Tracing.SampleRatio", 1.0)
+	v.SetDefault("Prometheus.ListenAddr", ":9100")
+	v.SetDefault("Prometheus.MetricsPath", "/metrics")
+}
+
+// Reads the config file, its values override defaults but not environment
+// variables.
+func readFile(v *viper.Viper, path string) error {
+	file := viper.New()
+	file.SetConfigFile(path)
+	if err := file.ReadInConfig(); err != nil {
+		return fmt.Errorf("couldn't read config file: %w", err)
+	}
+	settings := file.AllSettings()
+	if ext := filepath.Ext(path); ext == ".hcl" || ext == ".tfvars" {
+		settings = unwrapBlocks(settings).(map[string]any)
+	}
+	return v.MergeConfigMap(settings)
+}
+
+// HCL decodes blocks, eg. `proxy { ... }`, as lists holding a single map,
+// which would otherwise replace the defaults of the whole section.
+// Lists of a single rule, eg. `serviceDomains = [{ ... }]`, are unwrapped too,
+// they're decoded back into lists.
+func unwrapBlocks(value any) any {
+	switch v := value.(type) {
+	case []map[string]any:
+		if len(v) == 1 {
+			return unwrapBlocks(v[0])
+		}
+	case map[string]any:
+		for key, item := range v {
+			v[key] = unwrapBlocks(item)
+		}
+	}
+	return value
+}
+
+// Calls onChange whenever the config file is written or replaced, including
+// through a symbolic link as with Kubernetes config maps.
+func Watch(path string, onChange func()) {
+	file := viper.New()
+	file.SetConfigFile(path)
+	file.OnConfigChange(func(fsnotify.Event) {
+		onChange()
+	})
+	file.WatchConfig()
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// A secret, kept out of logs and JSON output.
+type Redacted string
+
+func (r Redacted) String() string {
+	if r == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (r Redacted) GoString() string {
+	return r.String()
+}
+
+func (r Redacted) LogValue() slog.Value {
+	return slog.StringValue(r.String())
+}
+
+func (r Redacted) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// Environment variable -> secret it sets.
+func (c *Config) secrets() map[string]*Redacted {
+	return map[string]*Redacted{
+		"PIHOLE_PASSWORD":       &c.Nameserver.Pihole.Password,
+		"AWS_ACCESS_KEY_ID":     &c.Nameserver.Route53.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY": &c.Nameserver.Route53.SecretAccessKey,
+		"AWS_SESSION_TOKEN":     &c.Nameserver.Route53.SessionToken,
+		"ADMIN_TOKEN":           &c.Admin.Token,
+		"CONSUL_HTTP_TOKEN":     &c.Discovery.ConsulToken,
+		"NOMAD_TOKEN":           &c.Discovery.NomadToken,
+	}
+}
+
+// Description -> secret of a named instance. They're only set in the config
+// file, possibly as Vault references.
+func (c *Config) instanceSecrets() map[string]*Redacted {
+	secrets := map[string]*Redacted{}
+	for i := range c.Nameserver.Instances {
+		instance := &c.Nameserver.Instances[i]
+		suffix := fmt.Sprintf(" of the %s nameserver", instance.Name)
+		secrets["the Pi-hole password"+suffix] = &instance.Pihole.Password
+		secrets["the AWS access key ID"+suffix] = &instance.Route53.AccessKeyID
+		secrets["the AWS secret access key"+suffix] = &instance.Route53.SecretAccessKey
+		secrets["the AWS session token"+suffix] = &instance.Route53.SessionToken
+	}
+	return secrets
+}
+
+// Environment variable -> comma-separated secrets it sets.
+func (c *Config) secretLists() map[string]*[]Redacted {
+	return map[string]*[]Redacted{
+		"NOTIFY_WEBHOOK_URLS": &c.Notifications.WebhookURLs,
+	}
+}
+
+// Reads secrets from the files set by <VARIABLE>_FILE, eg. Docker or Nomad
+// secrets. Trailing line breaks are trimmed.
+func readSecretFiles(secrets map[string]*Redacted) error {
+	for variable, secret := range secrets {
+		path := os.Getenv(variable + "_FILE")
+		if path == "" {
+			continue
+		}
+		if os.Getenv(variable) != "" {
+			return fmt.Errorf("only one of %s and %s_FILE can be set", variable, variable)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("couldn't read %s_FILE: %w", variable, err)
+		}
+		*secret = Redacted(strings.TrimRight(string(content), "\r\n"))
+	}
+	return nil
+}
+
+// Reads lists of secrets from the files set by <VARIABLE>_FILE, one secret per
+// line. Blank lines are ignored.
+func readSecretListFiles(secrets map[string]*[]Redacted) error {
+	for variable, list := range secrets {
+		path := os.Getenv(variable + "_FILE")
+		if path == "" {
+			continue
+		}
+		if os.Getenv(variable) != "" {
+			return fmt.Errorf("only one of %s and %s_FILE can be set", variable, variable)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("couldn't read %s_FILE: %w", variable, err)
+		}
+		*list = nil
+		for _, line := range strings.Split(string(content), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				*list = append(*list, Redacted(line))
+			}
+		}
+	}
+	return nil
+}
+
+// Prefix of secrets looked up in Vault, eg. "vault:secret/data/bingo#password"
+// for the "password" key of the "bingo" secret in the "secret" KV v2 engine.
+const vaultPrefix = "vault:"
+
+// Replaces secrets referencing Vault with their value, read from the KV engine.
+func resolveVaultSecrets(conf Vault, secrets map[string]*Redacted) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	for variable, secret := range secrets {
+		ref, found := strings.CutPrefix(string(*secret), vaultPrefix)
+		if !found {
+			continue
+		}
+		if conf.Addr == "" {
+			return fmt.Errorf("%s is read from Vault but VAULT_ADDR isn't set", variable)
+		}
+		path, key, found := strings.Cut(ref, "#")
+		if !found || path == "" || key == "" {
+			return fmt.Errorf("invalid Vault reference for %s, expected \"vault:<path>#<key>\"", variable)
+		}
+		value, err := readVaultKey(client, conf, path, key)
+		if err != nil {
+			return fmt.Errorf("couldn't read %s from Vault: %w", variable, err)
+		}
+		*secret = Redacted(value)
+	}
+	return nil
+}
+
+type vaultSecret struct {
+	Data map[string]any `json:"data"`
+}
+
+func readVaultKey(client *http.Client, conf Vault, path, key string) (string, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(conf.Addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", string(conf.Token))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("vault returned an unexpected status code for \"%s\": %d", path, resp.StatusCode)
+	}
+
+	secret := vaultSecret{}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("error parsing Vault secret \"%s\": %w", path, err)
+	}
+	data := secret.Data
+	// KV v2 nests the secret's data along with its metadata
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("no \"%s\" string in Vault secret \"%s\"", key, path)
+	}
+	return value, nil
+}
@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/n6g7/bingo/internal/config"
+)
+
+// Discovers hosts from the nodes running healthy instances of a Consul service.
+type ConsulDiscoverer struct {
+	addr       string
+	token      config.Redacted
+	client     *http.Client
+	service    string
+	hostSuffix string
+}
+
+func NewConsulDiscoverer(conf config.Discovery, service, hostSuffix string) *ConsulDiscoverer {
+	return &ConsulDiscoverer{
+		addr:       conf.ConsulAddr,
+		token:      conf.ConsulToken,
+		client:     &http.Client{Timeout: conf.Timeout},
+		service:    service,
+		hostSuffix: hostSuffix,
+	}
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Node string `json:"Node"`
+	} `json:"Node"`
+}
+
+func (c *ConsulDiscoverer) Discover() ([]string, error) {
+	url := c.addr + "/v1/health/service/" + url.PathEscape(c.service) + "?passing=true"
+	resp, err := get(c.client, url, "X-Consul-Token", c.token)
+	if err != nil {
+		return nil, fmt.Errorf("error querying Consul service \"%s\": %w", c.service, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("consul returned an unexpected status code: %d", resp.StatusCode)
+	}
+
+	output := []consulServiceEntry{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing Consul service body: %w", err)
+	}
+
+	hosts := []string{}
+	for _, entry := range output {
+		hosts = appendUnique(hosts, entry.Node.Node+c.hostSuffix)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,29 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Discovers hosts from the targets of a DNS SRV record.
+type DNSDiscoverer struct {
+	name string
+}
+
+func NewDNSDiscoverer(name string) *DNSDiscoverer {
+	return &DNSDiscoverer{name: name}
+}
+
+func (d *DNSDiscoverer) Discover() ([]string, error) {
+	_, srvs, err := net.LookupSRV("", "", d.name)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up SRV record \"%s\": %w", d.name, err)
+	}
+
+	hosts := []string{}
+	for _, srv := range srvs {
+		hosts = appendUnique(hosts, strings.TrimSuffix(srv.Target, "."))
+	}
+	return hosts, nil
+}
@@ -0,0 +1,6 @@
+package discovery
+
+// A Discoverer returns the current list of proxy hosts.
+type Discoverer interface {
+	Discover() ([]string, error)
+}
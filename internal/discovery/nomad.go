@@ -0,0 +1,83 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/n6g7/bingo/internal/config"
+)
+
+// Discovers hosts from the nodes running a Nomad service.
+type NomadDiscoverer struct {
+	addr       string
+	token      config.Redacted
+	client     *http.Client
+	service    string
+	hostSuffix string
+	nodeNames  map[string]string // node ID -> node name
+}
+
+func NewNomadDiscoverer(conf config.Discovery, service, hostSuffix string) *NomadDiscoverer {
+	return &NomadDiscoverer{
+		addr:       conf.NomadAddr,
+		token:      conf.NomadToken,
+		client:     &http.Client{Timeout: conf.Timeout},
+		service:    service,
+		hostSuffix: hostSuffix,
+		nodeNames:  map[string]string{},
+	}
+}
+
+type nomadServiceRegistration struct {
+	NodeID string `json:"NodeID"`
+}
+
+type nomadNode struct {
+	Name string `json:"Name"`
+}
+
+func (n *NomadDiscoverer) getJSON(uri string, output any) error {
+	resp, err := get(n.client, n.addr+uri, "X-Nomad-Token", n.token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("nomad returned an unexpected status code: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(output)
+}
+
+// Service registrations only contain node IDs, names are looked up once and cached.
+func (n *NomadDiscoverer) nodeName(id string) (string, error) {
+	if name, ok := n.nodeNames[id]; ok {
+		return name, nil
+	}
+	node := nomadNode{}
+	err := n.getJSON("/v1/node/"+url.PathEscape(id), &node)
+	if err != nil {
+		return "", fmt.Errorf("error querying Nomad node \"%s\": %w", id, err)
+	}
+	n.nodeNames[id] = node.Name
+	return node.Name, nil
+}
+
+func (n *NomadDiscoverer) Discover() ([]string, error) {
+	output := []nomadServiceRegistration{}
+	err := n.getJSON("/v1/service/"+url.PathEscape(n.service), &output)
+	if err != nil {
+		return nil, fmt.Errorf("error querying Nomad service \"%s\": %w", n.service, err)
+	}
+
+	hosts := []string{}
+	for _, registration := range output {
+		name, err := n.nodeName(registration.NodeID)
+		if err != nil {
+			return nil, err
+		}
+		hosts = appendUnique(hosts, name+n.hostSuffix)
+	}
+	return hosts, nil
+}
@@ -0,0 +1,13 @@
+package discovery
+
+type StaticDiscoverer struct {
+	hosts []string
+}
+
+func NewStaticDiscoverer(hosts []string) *StaticDiscoverer {
+	return &StaticDiscoverer{hosts: hosts}
+}
+
+func (s *StaticDiscoverer) Discover() ([]string, error) {
+	return s.hosts, nil
+}
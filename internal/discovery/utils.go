@@ -0,0 +1,28 @@
+package discovery
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/n6g7/bingo/internal/config"
+	"golang.org/x/exp/slices"
+)
+
+func appendUnique(hosts []string, host string) []string {
+	if slices.Contains(hosts, host) {
+		return hosts
+	}
+	return append(hosts, host)
+}
+
+// Sends a GET request, with the ACL token in the given header if it's set.
+func get(client *http.Client, url, tokenHeader string, token config.Redacted) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if token != "" {
+		req.Header.Set(tokenHeader, string(token))
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,81 @@
+// Package dnsname normalizes and validates host names, so that names coming
+// from proxies and nameservers can be compared.
+package dnsname
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	maxNameLength  = 253
+	maxLabelLength = 63
+)
+
+// Returns the canonical form of a host name: lowercase, ASCII (internationalised
+// labels are converted to punycode) and without trailing dot.
+// An error is returned if the name isn't a valid RFC 1035 host name.
+func Normalize(name string) (string, error) {
+	name = strings.TrimSuffix(strings.TrimSpace(name), ".")
+	if name == "" {
+		return "", fmt.Errorf("empty host name")
+	}
+
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", fmt.Errorf("invalid host name \"%s\": %w", name, err)
+	}
+	ascii = strings.ToLower(ascii)
+
+	if err := Validate(ascii); err != nil {
+		return "", err
+	}
+	return ascii, nil
+}
+
+// Checks the name is a valid RFC 1035 host name: at most 253 characters, made
+// of 1 to 63 character labels of letters, digits and hyphens that don't start
+// or end with a hyphen.
+func Validate(name string) error {
+	if len(name) > maxNameLength {
+		return fmt.Errorf("invalid host name \"%s\": longer than %d characters", name, maxNameLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return fmt.Errorf("invalid host name \"%s\": empty label", name)
+		}
+		if len(label) > maxLabelLength {
+			return fmt.Errorf("invalid host name \"%s\": label \"%s\" longer than %d characters", name, label, maxLabelLength)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid host name \"%s\": label \"%s\" starts or ends with a hyphen", name, label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("invalid host name \"%s\": label \"%s\" contains invalid character %q", name, label, c)
+			}
+		}
+	}
+	return nil
+}
+
+// Decodes the escape sequences Route 53 uses in record names, where characters
+// other than letters, digits, hyphens and dots are written as a backslash
+// followed by their three digit octal code (eg. "\052" for "*").
+func DecodeRoute53(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+4 <= len(name) {
+			if code, err := strconv.ParseUint(name[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package dnsname
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // empty if the name is invalid
+	}{
+		{"app.svc.local", "app.svc.local"},
+		{"app.svc.local.", "app.svc.local"},
+		{" App.SVC.local ", "app.svc.local"},
+		{"café.svc.local", "xn--caf-dma.svc.local"},
+		{"xn--caf-dma.svc.local", "xn--caf-dma.svc.local"},
+		{"my-app2.svc.local", "my-app2.svc.local"},
+		{"", ""},
+		{".", ""},
+		{"app..svc.local", ""},
+		{"-app.svc.local", ""},
+		{"app-.svc.local", ""},
+		{"my_app.svc.local", ""},
+		{"*.svc.local", ""},
+		{strings.Repeat("a", 64) + ".svc.local", ""},
+		{strings.Repeat("a.", 127) + "local", ""},
+	}
+	for _, tt := range tests {
+		got, err := Normalize(tt.name)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Normalize(%q) = %q, want an error", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Normalize(%q) failed: %v", tt.name, err)
+		} else if got != tt.want {
+			t.Errorf("Normalize(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeRoute53(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"app.svc.local.", "app.svc.local."},
+		{"\\052.svc.local.", "*.svc.local."},
+		{"my\\137app.svc.local.", "my_app.svc.local."},
+		{"xn--caf-dma.svc.local.", "xn--caf-dma.svc.local."},
+		{"trailing\\05", "trailing\\05"},
+		{"not\\999octal", "not\\999octal"},
+	}
+	for _, tt := range tests {
+		if got := DecodeRoute53(tt.name); got != tt.want {
+			t.Errorf("DecodeRoute53(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
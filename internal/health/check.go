@@ -0,0 +1,103 @@
+// Package health tracks the status of bingo's loops for the liveness and
+// readiness endpoints.
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+type Status = string
+
+const (
+	// Nothing was attempted yet.
+	Pending Status = "pending"
+	// The last attempt succeeded.
+	OK Status = "ok"
+	// The last attempt failed, but the last success, if any, is recent enough.
+	Failing Status = "failing"
+	// There was no success for too long.
+	Stale Status = "stale"
+)
+
+// The outcome of a periodic task (eg. listing records) and the liveness of the
+// loop running it.
+type Check struct {
+	mu          sync.Mutex
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastError   error
+	lastBeat    time.Time
+}
+
+func NewCheck() *Check {
+	return &Check{lastBeat: time.Now()}
+}
+
+func (c *Check) Succeed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastSuccess = time.Now()
+	c.lastBeat = c.lastSuccess
+}
+
+func (c *Check) Fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastFailure = time.Now()
+	c.lastError = err
+	c.lastBeat = c.lastFailure
+}
+
+// Records that the loop running the task is still going.
+func (c *Check) Beat() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastBeat = time.Now()
+}
+
+type Report struct {
+	Status      Status     `json:"status"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Reports the status of the check. It's stale when it didn't succeed for
+// staleAfter, which 0 disables.
+func (c *Check) Report(now time.Time, staleAfter time.Duration) Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := Report{}
+	if !c.lastSuccess.IsZero() {
+		lastSuccess := c.lastSuccess
+		report.LastSuccess = &lastSuccess
+	}
+	if !c.lastFailure.IsZero() {
+		lastFailure := c.lastFailure
+		report.LastFailure = &lastFailure
+	}
+	failing := c.lastFailure.After(c.lastSuccess)
+	if failing {
+		report.Error = c.lastError.Error()
+	}
+
+	switch {
+	case c.lastSuccess.IsZero() && !failing:
+		report.Status = Pending
+	case !c.lastSuccess.IsZero() && staleAfter > 0 && now.Sub(c.lastSuccess) > staleAfter:
+		report.Status = Stale
+	case failing:
+		report.Status = Failing
+	default:
+		report.Status = OK
+	}
+	return report
+}
+
+// Returns whether the loop running the task went on within the timeout.
+func (c *Check) Alive(now time.Time, timeout time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return now.Sub(c.lastBeat) <= timeout
+}
@@ -0,0 +1,141 @@
+package leader
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A Consul KV lock, held through a session that must be renewed before its TTL
+// expires. The lock is released when the session expires.
+type ConsulLock struct {
+	addr     string
+	token    string
+	key      string
+	identity string
+	ttl      time.Duration
+	session  string
+	client   *http.Client
+}
+
+func NewConsulLock(addr, token, key, identity string, ttl time.Duration) *ConsulLock {
+	return &ConsulLock{
+		addr:     addr,
+		token:    token,
+		key:      key,
+		identity: identity,
+		ttl:      ttl,
+		// A hung request mustn't outlive the session
+		client: &http.Client{Timeout: ttl / 3},
+	}
+}
+
+func (c *ConsulLock) put(path string, body string) (*http.Response, error) {
+	req, err := http.NewRequest("PUT", c.addr+path, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	return c.client.Do(req)
+}
+
+func (c *ConsulLock) createSession() error {
+	body, err := json.Marshal(map[string]string{
+		"Name":     "bingo-" + c.identity,
+		"TTL":      c.ttl.String(),
+		"Behavior": "release",
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding session: %w", err)
+	}
+	resp, err := c.put("/v1/session/create", string(body))
+	if err != nil {
+		return fmt.Errorf("error creating Consul session: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("consul returned an unexpected status code: %d", resp.StatusCode)
+	}
+
+	output := struct{ ID string }{}
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	if err != nil {
+		return fmt.Errorf("error parsing Consul session body: %w", err)
+	}
+	c.session = output.ID
+	return nil
+}
+
+// Returns false if the session expired.
+func (c *ConsulLock) renewSession() (bool, error) {
+	resp, err := c.put("/v1/session/renew/"+url.PathEscape(c.session), "")
+	if err != nil {
+		return false, fmt.Errorf("error renewing Consul session: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 200:
+		return true, nil
+	case 404:
+		return false, nil
+	default:
+		return false, fmt.Errorf("consul returned an unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+func (c *ConsulLock) TryAcquire() (bool, error) {
+	if c.session != "" {
+		renewed, err := c.renewSession()
+		if err != nil {
+			return false, err
+		}
+		if !renewed {
+			c.session = ""
+		}
+	}
+	if c.session == "" {
+		if err := c.createSession(); err != nil {
+			return false, err
+		}
+	}
+
+	// Acquiring a lock already held by the session succeeds
+	resp, err := c.put("/v1/kv/"+c.key+"?acquire="+url.QueryEscape(c.session), c.identity)
+	if err != nil {
+		return false, fmt.Errorf("error acquiring Consul lock \"%s\": %w", c.key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false, fmt.Errorf("consul returned an unexpected status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("error reading Consul lock body: %w", err)
+	}
+	return strings.TrimSpace(string(body)) == "true", nil
+}
+
+func (c *ConsulLock) Release() error {
+	if c.session == "" {
+		return nil
+	}
+	resp, err := c.put("/v1/kv/"+c.key+"?release="+url.QueryEscape(c.session), "")
+	if err != nil {
+		return fmt.Errorf("error releasing Consul lock \"%s\": %w", c.key, err)
+	}
+	resp.Body.Close()
+
+	resp, err = c.put("/v1/session/destroy/"+url.PathEscape(c.session), "")
+	if err != nil {
+		return fmt.Errorf("error destroying Consul session: %w", err)
+	}
+	resp.Body.Close()
+	c.session = ""
+	return nil
+}
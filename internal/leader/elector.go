@@ -0,0 +1,115 @@
+// Package leader elects a single active bingo instance among several replicas.
+package leader
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/n6g7/nomtail/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var leaderGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bingo_leader",
+	Help: "Whether this instance is the leader (1) or a standby (0)",
+})
+
+// Periodically attempts to acquire or renew a lock, the instance holding it
+// being the leader.
+type Elector struct {
+	logger        *log.Logger
+	lock          Lock
+	retryInterval time.Duration
+	ttl           time.Duration
+	mu            sync.Mutex // serializes lock operations
+	released      bool
+	leader        atomic.Bool
+	expiry        atomic.Int64 // unix nanoseconds
+}
+
+func NewElector(logger *log.Logger, lock Lock, retryInterval, ttl time.Duration) *Elector {
+	return &Elector{
+		logger:        logger.With("component", "leader"),
+		lock:          lock,
+		retryInterval: retryInterval,
+		ttl:           ttl,
+	}
+}
+
+// Leadership is considered lost this long before the lock could expire, to
+// account for clock drift and request latency.
+func (e *Elector) margin() time.Duration {
+	return e.ttl / 4
+}
+
+// Leadership expires when it wasn't renewed in time, even if the lock
+// operation renewing it is still pending.
+func (e *Elector) IsLeader() bool {
+	if !e.leader.Load() {
+		return false
+	}
+	if time.Now().UnixNano() < e.expiry.Load() {
+		return true
+	}
+	if e.leader.CompareAndSwap(true, false) {
+		e.logger.Warn("leadership expired without being renewed, standing by")
+		leaderGauge.Set(0)
+	}
+	return false
+}
+
+func (e *Elector) setLeader(leader bool) {
+	if leader != e.leader.Load() {
+		if leader {
+			e.logger.Info("became the leader")
+		} else {
+			e.logger.Warn("not the leader anymore, standing by")
+		}
+	}
+	e.leader.Store(leader)
+	if leader {
+		leaderGauge.Set(1)
+	} else {
+		leaderGauge.Set(0)
+	}
+}
+
+func (e *Elector) tick() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.released {
+		return
+	}
+	start := time.Now()
+	held, err := e.lock.TryAcquire()
+	if err != nil {
+		// Leadership can't be confirmed, step down rather than risk two leaders
+		e.logger.Error("error acquiring leadership", "err", err)
+		held = false
+	}
+	// The lock's TTL started at the latest when the request was sent
+	e.expiry.Store(start.Add(e.ttl - e.margin()).UnixNano())
+	e.setLeader(held)
+}
+
+func (e *Elector) Run() {
+	e.logger.Info("starting leader election", "retry_interval", e.retryInterval)
+	leaderGauge.Set(0)
+	for {
+		e.tick()
+		time.Sleep(e.retryInterval)
+	}
+}
+
+// Steps down and releases the lock, leadership isn't attempted anymore.
+func (e *Elector) Release() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.released = true
+	e.leader.Store(false)
+	leaderGauge.Set(0)
+	e.logger.Info("releasing leadership")
+	return e.lock.Release()
+}
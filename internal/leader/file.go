@@ -0,0 +1,65 @@
+//go:build unix
+
+package leader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// An advisory lock on a local file, for running several instances on a single
+// host. The lock is released by the system if the instance dies.
+type FileLock struct {
+	path     string
+	identity string
+	file     *os.File
+}
+
+func NewFileLock(path, identity string) *FileLock {
+	return &FileLock{
+		path:     path,
+		identity: identity,
+	}
+}
+
+func (f *FileLock) TryAcquire() (bool, error) {
+	if f.file != nil {
+		return true, nil
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return false, fmt.Errorf("error opening lock file: %w", err)
+	}
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		file.Close()
+		return false, nil
+	}
+	if err != nil {
+		file.Close()
+		return false, fmt.Errorf("error locking lock file: %w", err)
+	}
+
+	// For humans wondering who holds the lock
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(f.identity+"\n"), 0)
+	}
+	f.file = file
+	return true, nil
+}
+
+func (f *FileLock) Release() error {
+	if f.file == nil {
+		return nil
+	}
+	err := syscall.Flock(int(f.file.Fd()), syscall.LOCK_UN)
+	f.file.Close()
+	f.file = nil
+	if err != nil {
+		return fmt.Errorf("error unlocking lock file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package leader
+
+import "fmt"
+
+type FileLock struct{}
+
+func NewFileLock(path, identity string) *FileLock {
+	return &FileLock{}
+}
+
+func (f *FileLock) TryAcquire() (bool, error) {
+	return false, fmt.Errorf("file locks aren't supported on this platform")
+}
+
+func (f *FileLock) Release() error {
+	return nil
+}
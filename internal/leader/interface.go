@@ -0,0 +1,11 @@
+package leader
+
+// A lock held by at most one bingo instance at a time, the leader.
+type Lock interface {
+	// Acquires the lock, or renews it if it's already held. Returns whether
+	// it's held.
+	TryAcquire() (bool, error)
+	// Releases the lock if it's held, so that another instance can take over
+	// without waiting for it to expire.
+	Release() error
+}
@@ -0,0 +1,222 @@
+package leader
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Format of Kubernetes MicroTime fields.
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+}
+
+// A Kubernetes coordination.k8s.io/v1 Lease, accessed with the pod's service
+// account.
+// Expiry is measured from when this instance last saw the lease change rather
+// than from its renew time, so that clock skew between instances doesn't matter.
+type KubernetesLease struct {
+	client    *http.Client
+	url       string
+	name      string
+	namespace string
+	identity  string
+	ttl       time.Duration
+
+	observed   leaseSpec
+	observedAt time.Time
+}
+
+func NewKubernetesLease(name, namespace, identity string, ttl time.Duration) (*KubernetesLease, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+
+	if namespace == "" {
+		content, err := os.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("error reading service account namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(content))
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid service account CA")
+	}
+
+	return &KubernetesLease{
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		url: fmt.Sprintf(
+			"https://%s/apis/coordination.k8s.io/v1/namespaces/%s/leases",
+			net.JoinHostPort(host, port),
+			url.PathEscape(namespace),
+		),
+		name:      name,
+		namespace: namespace,
+		identity:  identity,
+		ttl:       ttl,
+	}, nil
+}
+
+// Sends a request to the Kubernetes API, returns the response status code.
+func (k *KubernetesLease) do(method, url string, requestBody *lease, responseBody *lease) (int, error) {
+	var body io.Reader
+	if requestBody != nil {
+		encoded, err := json.Marshal(requestBody)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		body = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	// Service account tokens are rotated, read it every time
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return 0, fmt.Errorf("error reading service account token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == 404 || resp.StatusCode == 409:
+		return resp.StatusCode, nil
+	case resp.StatusCode >= 400:
+		content, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("status %s: %s", resp.Status, content)
+	}
+	if responseBody != nil {
+		if err := json.NewDecoder(resp.Body).Decode(responseBody); err != nil {
+			return resp.StatusCode, fmt.Errorf("failed to decode response body: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (k *KubernetesLease) TryAcquire() (bool, error) {
+	now := time.Now()
+	current := &lease{}
+	status, err := k.do("GET", k.url+"/"+url.PathEscape(k.name), nil, current)
+	if err != nil {
+		return false, fmt.Errorf("error getting lease \"%s\": %w", k.name, err)
+	}
+
+	spec := leaseSpec{
+		HolderIdentity:       k.identity,
+		LeaseDurationSeconds: int(k.ttl.Seconds()),
+		AcquireTime:          now.UTC().Format(microTime),
+		RenewTime:            now.UTC().Format(microTime),
+	}
+
+	if status == 404 {
+		status, err = k.do("POST", k.url, &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: k.name, Namespace: k.namespace},
+			Spec:       spec,
+		}, nil)
+		if err != nil {
+			return false, fmt.Errorf("error creating lease \"%s\": %w", k.name, err)
+		}
+		// 409: another instance created it first
+		return status != 409, nil
+	}
+
+	if current.Spec != k.observed {
+		k.observed = current.Spec
+		k.observedAt = now
+	}
+	holder := current.Spec.HolderIdentity
+	if holder != "" && holder != k.identity {
+		duration := time.Duration(current.Spec.LeaseDurationSeconds) * time.Second
+		if now.Before(k.observedAt.Add(duration)) {
+			return false, nil
+		}
+	}
+
+	spec.LeaseTransitions = current.Spec.LeaseTransitions
+	if holder == k.identity {
+		spec.AcquireTime = current.Spec.AcquireTime
+	} else {
+		spec.LeaseTransitions++
+	}
+	current.Spec = spec
+	status, err = k.do("PUT", k.url+"/"+url.PathEscape(k.name), current, current)
+	if err != nil {
+		return false, fmt.Errorf("error updating lease \"%s\": %w", k.name, err)
+	}
+	if status == 409 {
+		// Updated by another instance in the meantime
+		return false, nil
+	}
+	k.observed = current.Spec
+	k.observedAt = now
+	return true, nil
+}
+
+func (k *KubernetesLease) Release() error {
+	current := &lease{}
+	status, err := k.do("GET", k.url+"/"+url.PathEscape(k.name), nil, current)
+	if err != nil {
+		return fmt.Errorf("error getting lease \"%s\": %w", k.name, err)
+	}
+	if status == 404 || current.Spec.HolderIdentity != k.identity {
+		return nil
+	}
+
+	current.Spec.HolderIdentity = ""
+	current.Spec.LeaseDurationSeconds = 1
+	current.Spec.RenewTime = time.Now().UTC().Format(microTime)
+	_, err = k.do("PUT", k.url+"/"+url.PathEscape(k.name), current, nil)
+	if err != nil {
+		return fmt.Errorf("error releasing lease \"%s\": %w", k.name, err)
+	}
+	return nil
+}
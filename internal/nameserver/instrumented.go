@@ -0,0 +1,107 @@
+package nameserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/n6g7/bingo/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/n6g7/bingo/internal/nameserver")
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bingo_nameserver_request_duration_seconds",
+		Help:    "Duration of nameserver requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "host", "operation"})
+	requestErrorCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bingo_nameserver_request_errors",
+		Help: "The total number of failed nameserver requests",
+	}, []string{"backend", "host", "operation"})
+	lastSuccessGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_nameserver_last_success_timestamp_seconds",
+		Help: "When a nameserver request last succeeded, as a Unix timestamp",
+	}, []string{"backend", "host", "operation"})
+)
+
+// Wraps a nameserver to record the duration and outcome of its calls, along
+// with a span for each of them.
+type instrumented struct {
+	ns      Nameserver
+	backend string
+	host    string
+}
+
+func Instrument(ns Nameserver, backend, host string) Nameserver {
+	return &instrumented{
+		ns:      ns,
+		backend: backend,
+		host:    host,
+	}
+}
+
+func (i *instrumented) start(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("backend", i.backend), attribute.String("host", i.host))
+	return tracer.Start(ctx, i.backend+"."+operation, trace.WithAttributes(attrs...))
+}
+
+func (i *instrumented) observe(span trace.Span, operation string, start time.Time, err error) {
+	tracing.End(span, err)
+	requestDuration.WithLabelValues(i.backend, i.host, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requestErrorCounter.WithLabelValues(i.backend, i.host, operation).Inc()
+		return
+	}
+	lastSuccessGauge.WithLabelValues(i.backend, i.host, operation).SetToCurrentTime()
+}
+
+func (i *instrumented) Init(ctx context.Context) error {
+	start := time.Now()
+	ctx, span := i.start(ctx, "init")
+	err := i.ns.Init(ctx)
+	i.observe(span, "init", start, err)
+	return err
+}
+
+func (i *instrumented) ListRecords(ctx context.Context) ([]Record, error) {
+	start := time.Now()
+	ctx, span := i.start(ctx, "list_records")
+	records, err := i.ns.ListRecords(ctx)
+	span.SetAttributes(attribute.Int("records", len(records)))
+	i.observe(span, "list_records", start, err)
+	return records, err
+}
+
+func (i *instrumented) RemoveRecord(ctx context.Context, name string) error {
+	start := time.Now()
+	ctx, span := i.start(ctx, "remove_record", attribute.String("domain", name))
+	err := i.ns.RemoveRecord(ctx, name)
+	i.observe(span, "remove_record", start, err)
+	return err
+}
+
+func (i *instrumented) AddRecord(ctx context.Context, name, cname string, ttl int64) error {
+	start := time.Now()
+	ctx, span := i.start(ctx, "add_record", attribute.String("domain", name), attribute.String("target", cname))
+	err := i.ns.AddRecord(ctx, name, cname, ttl)
+	i.observe(span, "add_record", start, err)
+	return err
+}
+
+func (i *instrumented) UpdateRecord(ctx context.Context, name, cname string, ttl int64) error {
+	start := time.Now()
+	ctx, span := i.start(ctx, "update_record", attribute.String("domain", name), attribute.String("target", cname))
+	err := i.ns.UpdateRecord(ctx, name, cname, ttl)
+	i.observe(span, "update_record", start, err)
+	return err
+}
+
+func (i *instrumented) DefaultTTL() int64 {
+	return i.ns.DefaultTTL()
+}
@@ -1,13 +1,39 @@
 package nameserver
 
+import "context"
+
+type RecordType = string
+
+const (
+	CNAME RecordType = "CNAME"
+	A     RecordType = "A"
+	AAAA  RecordType = "AAAA"
+)
+
 type Record struct {
-	Name  string
-	Cname string
+	Name string     `json:"name"`
+	Type RecordType `json:"type"`
+	// Zero if the record uses the nameserver's default TTL.
+	TTL int64 `json:"ttl"`
+	// All the values held for the name, a healthy CNAME record has exactly one.
+	Values []string `json:"values"`
 }
 
 type Nameserver interface {
-	Init() error
-	ListRecords() ([]Record, error)
-	RemoveRecord(name string) error
-	AddRecord(name, cname string) error
+	Init(ctx context.Context) error
+	// Lists CNAME records, as well as records of other types conflicting with
+	// them (eg. A records). There is a single record per name and type.
+	ListRecords(ctx context.Context) ([]Record, error)
+	// Removes all records for the name.
+	RemoveRecord(ctx context.Context, name string) error
+	// Creates a CNAME record, with the backend's default TTL if ttl is zero.
+	AddRecord(ctx context.Context, name, cname string, ttl int64) error
+	// Points an existing record at a new target without deleting it first,
+	// replacing all its values and its TTL.
+	// Backends without an atomic update should add the new record before
+	// removing the old one.
+	UpdateRecord(ctx context.Context, name, cname string, ttl int64) error
+	// Returns the TTL records get when created with a zero TTL, as reported by
+	// ListRecords.
+	DefaultTTL() int64
 }
@@ -1,13 +1,18 @@
 package nameserver
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net/http"
 	"net/http/cookiejar"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/dnsname"
+	"github.com/n6g7/bingo/internal/tracing"
 	"github.com/n6g7/nomtail/pkg/log"
 )
 
@@ -22,13 +27,13 @@ func NewPiholeNS(logger *log.Logger, conf config.PiholeConf) *PiholeNS {
 	return &PiholeNS{
 		logger:   logger.With("component", "pi-hole"),
 		baseURL:  conf.URL,
-		password: conf.Password,
+		password: string(conf.Password),
 	}
 }
 
 // Send an HTTP request to the Pi-hole, with built-in CSRF token refresh.
-func (ph *PiholeNS) do(method, uri string, reqBody, respBody any) error {
-	req, err := http.NewRequest(method, ph.baseURL+uri, nil)
+func (ph *PiholeNS) do(ctx context.Context, method, uri string, reqBody, respBody any) error {
+	req, err := http.NewRequestWithContext(ctx, method, ph.baseURL+uri, nil)
 	if err != nil {
 		return fmt.Errorf("failed to build request: %w", err)
 	}
@@ -36,11 +41,11 @@ func (ph *PiholeNS) do(method, uri string, reqBody, respBody any) error {
 	if err != nil {
 		// If we get a 401, it probably means the CSRF token has expired. Login again to refresh it.
 		if strings.Contains(err.Error(), "status 401 Unauthorized") {
-			if err := ph.login(); err != nil {
+			if err := ph.login(ctx); err != nil {
 				return fmt.Errorf("failed to login while refreshing CSRF token: %w", err)
 			}
 			// Try again.
-			return ph.do(method, uri, reqBody, respBody)
+			return ph.do(ctx, method, uri, reqBody, respBody)
 		}
 		return err
 	}
@@ -58,9 +63,10 @@ type loginResponse struct {
 	} `json:"session"`
 }
 
-func (ph *PiholeNS) login() error {
+func (ph *PiholeNS) login(ctx context.Context) error {
 	var response loginResponse
 	err := ph.do(
+		ctx,
 		"POST",
 		"/api/auth",
 		&loginRequest{Password: ph.password},
@@ -80,7 +86,7 @@ func (ph *PiholeNS) login() error {
 	return nil
 }
 
-func (ph *PiholeNS) Init() error {
+func (ph *PiholeNS) Init(ctx context.Context) error {
 	// Create HTTP client
 	transport := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // Ignore invalid certs
@@ -90,12 +96,12 @@ func (ph *PiholeNS) Init() error {
 		return fmt.Errorf("cookie jar creation failed: %w", err)
 	}
 	ph.client = &JsonClient{Client: http.Client{
-		Transport: transport,
+		Transport: tracing.Transport(transport),
 		Jar:       jar,
 	}}
 
 	// Initial login
-	return ph.login()
+	return ph.login(ctx)
 }
 
 type ListResult struct {
@@ -108,43 +114,147 @@ type ListResult struct {
 	} `json:"config"`
 }
 
-func (ph *PiholeNS) ListRecords() ([]Record, error) {
+// Parses a raw CNAME row into a record with normalized names.
+func parseRow(row string) (Record, error) {
+	items := strings.Split(row, ",")
+	if len(items) < 2 {
+		return Record{}, fmt.Errorf("expected at least 2 fields")
+	}
+	name, err := dnsname.Normalize(items[0])
+	if err != nil {
+		return Record{}, err
+	}
+	cname, err := dnsname.Normalize(items[1])
+	if err != nil {
+		return Record{}, err
+	}
+	var ttl int64
+	if len(items) > 2 {
+		ttl, err = strconv.ParseInt(items[2], 10, 64)
+		if err != nil {
+			return Record{}, fmt.Errorf("invalid TTL: %w", err)
+		}
+	}
+	return Record{
+		Name:   name,
+		Type:   CNAME,
+		TTL:    ttl,
+		Values: []string{cname},
+	}, nil
+}
+
+// Returns the raw CNAME rows, formatted as "name,target[,ttl]".
+func (ph *PiholeNS) listRows(ctx context.Context) ([]string, error) {
 	output := &ListResult{}
-	err := ph.do("GET", "/api/config/dns/cnameRecords?detailed=true", nil, output)
+	err := ph.do(ctx, "GET", "/api/config/dns/cnameRecords?detailed=true", nil, output)
 	if err != nil {
 		return nil, err
 	}
+	return output.Config.DNS.CNAMERecords.Value, nil
+}
 
+func (ph *PiholeNS) ListRecords(ctx context.Context) ([]Record, error) {
+	rows, err := ph.listRows(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pi-hole can hold several rows for the same name, merge them in a single
+	// record with several values.
 	records := []Record{}
-	for _, row := range output.Config.DNS.CNAMERecords.Value {
-		items := strings.Split(row, ",")
-		records = append(records, Record{items[0], items[1]})
+	indexes := map[string]int{}
+	for _, row := range rows {
+		record, err := parseRow(row)
+		if err != nil {
+			ph.logger.Debug("ignoring invalid CNAME record", "row", row, "err", err)
+			continue
+		}
+		if i, ok := indexes[record.Name]; ok {
+			records[i].Values = append(records[i].Values, record.Values...)
+			continue
+		}
+		indexes[record.Name] = len(records)
+		records = append(records, record)
 	}
 	return records, nil
 }
 
-func (ph *PiholeNS) AddRecord(name, cname string) error {
-	url := fmt.Sprintf("/api/config/dns/cnameRecords/%s%%2C%s", name, cname)
-	return ph.do("PUT", url, nil, nil)
+func (ph *PiholeNS) AddRecord(ctx context.Context, name, cname string, ttl int64) error {
+	row := formatRow(name, cname, ttl)
+	return ph.do(ctx, "PUT", "/api/config/dns/cnameRecords/"+url.PathEscape(row), nil, nil)
+}
+
+// Records without an explicit TTL use Pi-hole's local TTL setting.
+func (ph *PiholeNS) DefaultTTL() int64 {
+	return 0
+}
+
+func formatRow(name, cname string, ttl int64) string {
+	row := name + "," + cname
+	if ttl > 0 {
+		row += fmt.Sprintf(",%d", ttl)
+	}
+	return row
 }
 
-func (ph *PiholeNS) RemoveRecord(name string) error {
-	// We need to know the target domain in order to delete ...
-	records, err := ph.ListRecords()
+type patchCNAMERecordsRequest struct {
+	Config struct {
+		DNS struct {
+			CNAMERecords []string `json:"cnameRecords"`
+		} `json:"dns"`
+	} `json:"config"`
+}
+
+// Replaces the record's row in a single config PATCH, so that the domain never
+// stops resolving.
+func (ph *PiholeNS) UpdateRecord(ctx context.Context, name, cname string, ttl int64) error {
+	rows, err := ph.listRows(ctx)
+	if err != nil {
+		return err
+	}
+
+	newRows := []string{}
+	found := false
+	for _, row := range rows {
+		if record, err := parseRow(row); err == nil && record.Name == name {
+			if !found {
+				newRows = append(newRows, formatRow(name, cname, ttl))
+				found = true
+			}
+			continue
+		}
+		newRows = append(newRows, row)
+	}
+	if !found {
+		return fmt.Errorf("couldn't find record for domain %s", name)
+	}
+
+	request := &patchCNAMERecordsRequest{}
+	request.Config.DNS.CNAMERecords = newRows
+	return ph.do(ctx, "PATCH", "/api/config", request, nil)
+}
+
+func (ph *PiholeNS) RemoveRecord(ctx context.Context, name string) error {
+	// We need the complete row (including target and TTL) in order to delete ...
+	rows, err := ph.listRows(ctx)
 	if err != nil {
 		return err
 	}
-	target := ""
-	for _, record := range records {
-		if record.Name == name {
-			target = record.Cname
-			break
+	selectedRows := []string{}
+	for _, row := range rows {
+		if record, err := parseRow(row); err == nil && record.Name == name {
+			selectedRows = append(selectedRows, row)
 		}
 	}
-	if target == "" {
+	if len(selectedRows) == 0 {
 		return fmt.Errorf("couldn't find target for domain %s", name)
 	}
 
-	url := fmt.Sprintf("/api/config/dns/cnameRecords/%s%%2C%s", name, target)
-	return ph.do("DELETE", url, nil, nil)
+	for _, row := range selectedRows {
+		err := ph.do(ctx, "DELETE", "/api/config/dns/cnameRecords/"+url.PathEscape(row), nil, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
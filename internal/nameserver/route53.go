@@ -3,11 +3,15 @@ package nameserver
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/route53"
 	"github.com/aws/aws-sdk-go-v2/service/route53/types"
 	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/dnsname"
+	"github.com/n6g7/bingo/internal/tracing"
 	"github.com/n6g7/nomtail/pkg/log"
 )
 
@@ -17,6 +21,10 @@ type Route53NS struct {
 	recordType types.RRType
 	ttl        *int64
 	region     string
+	// Static credentials, if set.
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
 
 	hostedZoneId *string
 	client       *route53.Client
@@ -29,14 +37,24 @@ func NewRoute53NS(logger *log.Logger, conf config.Route53Conf) *Route53NS {
 		recordType: types.RRTypeCname,
 		ttl:        &conf.TTL,
 		region:     conf.AWSRegion,
+
+		accessKeyID:     string(conf.AccessKeyID),
+		secretAccessKey: string(conf.SecretAccessKey),
+		sessionToken:    string(conf.SessionToken),
 	}
 }
 
-func (r *Route53NS) Init() error {
-	cfg, err := awsConfig.LoadDefaultConfig(
-		context.TODO(),
+func (r *Route53NS) Init(ctx context.Context) error {
+	options := []func(*awsConfig.LoadOptions) error{
 		awsConfig.WithRegion(r.region),
-	)
+		awsConfig.WithHTTPClient(&http.Client{Transport: tracing.Transport(http.DefaultTransport)}),
+	}
+	if r.accessKeyID != "" {
+		options = append(options, awsConfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(r.accessKeyID, r.secretAccessKey, r.sessionToken),
+		))
+	}
+	cfg, err := awsConfig.LoadDefaultConfig(ctx, options...)
 	if err != nil {
 		return fmt.Errorf("error loading AWS config :%w", err)
 	}
@@ -45,7 +63,7 @@ func (r *Route53NS) Init() error {
 	r.client = client
 
 	// Check hosted zone exists
-	output, err := r.client.ListHostedZonesByName(context.TODO(), &route53.ListHostedZonesByNameInput{
+	output, err := r.client.ListHostedZonesByName(ctx, &route53.ListHostedZonesByNameInput{
 		DNSName: r.hostedZone,
 	})
 	if err != nil {
@@ -63,9 +81,14 @@ func (r *Route53NS) Init() error {
 	return nil
 }
 
-func (r *Route53NS) listRecordSets() ([]types.ResourceRecordSet, error) {
+// Decodes and normalizes a name returned by Route 53, eg. "MyApp.svc.local.".
+func decodeName(name string) (string, error) {
+	return dnsname.Normalize(dnsname.DecodeRoute53(name))
+}
+
+func (r *Route53NS) listRecordSets(ctx context.Context) ([]types.ResourceRecordSet, error) {
 	outputs, err := r.client.ListResourceRecordSets(
-		context.TODO(),
+		ctx,
 		&route53.ListResourceRecordSetsInput{
 			HostedZoneId: r.hostedZoneId,
 		},
@@ -76,53 +99,77 @@ func (r *Route53NS) listRecordSets() ([]types.ResourceRecordSet, error) {
 	return outputs.ResourceRecordSets, nil
 }
 
-func (r *Route53NS) ListRecords() (records []Record, err error) {
-	rrsets, err := r.listRecordSets()
+// Returns whether bingo cares about record sets of this type: CNAMEs, and types
+// that can't coexist with a CNAME of the same name.
+func (r *Route53NS) isManagedType(rrType types.RRType) bool {
+	return rrType == r.recordType || rrType == types.RRTypeA || rrType == types.RRTypeAaaa
+}
+
+func (r *Route53NS) ListRecords(ctx context.Context) (records []Record, err error) {
+	rrsets, err := r.listRecordSets(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, rrs := range rrsets {
-		if rrs.Type != r.recordType {
+		if !r.isManagedType(rrs.Type) {
 			continue
 		}
+		name, err := decodeName(*rrs.Name)
+		if err != nil {
+			r.logger.Debug("ignoring record set with invalid name", "name", *rrs.Name, "err", err)
+			continue
+		}
+
+		record := Record{
+			Name:   name,
+			Type:   string(rrs.Type),
+			Values: []string{},
+		}
+		// Alias record sets don't have a TTL
+		if rrs.TTL != nil {
+			record.TTL = *rrs.TTL
+		}
 		for _, rr := range rrs.ResourceRecords {
-			records = append(records, Record{
-				Name:  (*rrs.Name)[:len(*rrs.Name)-1],
-				Cname: *rr.Value,
-			})
+			value := *rr.Value
+			if rrs.Type == r.recordType {
+				value, err = decodeName(value)
+				if err != nil {
+					r.logger.Debug("ignoring record with invalid target", "name", name, "target", *rr.Value, "err", err)
+					continue
+				}
+			}
+			record.Values = append(record.Values, value)
 		}
+		records = append(records, record)
 	}
 	return
 }
 
-func (r *Route53NS) RemoveRecord(name string) error {
-	rrsets, err := r.listRecordSets()
+func (r *Route53NS) RemoveRecord(ctx context.Context, name string) error {
+	rrsets, err := r.listRecordSets(ctx)
 	if err != nil {
 		return err
 	}
 
-	var selectedRRS *types.ResourceRecordSet = nil
+	changes := []types.Change{}
 	for _, rrs := range rrsets {
-		if *rrs.Name == name || *rrs.Name == name+"." {
-			selectedRRS = &rrs
-			break
+		if rrsName, err := decodeName(*rrs.Name); err == nil && rrsName == name && r.isManagedType(rrs.Type) {
+			changes = append(changes, types.Change{
+				Action:            types.ChangeActionDelete,
+				ResourceRecordSet: &rrs,
+			})
 		}
 	}
 
-	if selectedRRS == nil {
+	if len(changes) == 0 {
 		return fmt.Errorf("could not find record set for \"%s\", nothing to delete", name)
 	}
 
-	_, err = r.client.ChangeResourceRecordSets(context.TODO(), &route53.ChangeResourceRecordSetsInput{
+	_, err = r.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
 		HostedZoneId: r.hostedZoneId,
 		ChangeBatch: &types.ChangeBatch{
-			Changes: []types.Change{
-				{
-					Action:            types.ChangeActionDelete,
-					ResourceRecordSet: selectedRRS,
-				},
-			},
+			Changes: changes,
 		},
 	})
 	if err != nil {
@@ -131,17 +178,24 @@ func (r *Route53NS) RemoveRecord(name string) error {
 	return nil
 }
 
-func (r *Route53NS) AddRecord(name, cname string) error {
-	_, err := r.client.ChangeResourceRecordSets(context.TODO(), &route53.ChangeResourceRecordSetsInput{
+func (r *Route53NS) DefaultTTL() int64 {
+	return *r.ttl
+}
+
+func (r *Route53NS) changeRecord(ctx context.Context, action types.ChangeAction, name, cname string, ttl int64) error {
+	if ttl <= 0 {
+		ttl = *r.ttl
+	}
+	_, err := r.client.ChangeResourceRecordSets(ctx, &route53.ChangeResourceRecordSetsInput{
 		HostedZoneId: r.hostedZoneId,
 		ChangeBatch: &types.ChangeBatch{
 			Changes: []types.Change{
 				{
-					Action: types.ChangeActionCreate,
+					Action: action,
 					ResourceRecordSet: &types.ResourceRecordSet{
 						Name: &name,
 						Type: r.recordType,
-						TTL:  r.ttl,
+						TTL:  &ttl,
 						ResourceRecords: []types.ResourceRecord{
 							{
 								Value: &cname,
@@ -152,8 +206,21 @@ func (r *Route53NS) AddRecord(name, cname string) error {
 			},
 		},
 	})
+	return err
+}
+
+func (r *Route53NS) AddRecord(ctx context.Context, name, cname string, ttl int64) error {
+	err := r.changeRecord(ctx, types.ChangeActionCreate, name, cname, ttl)
 	if err != nil {
 		return fmt.Errorf("error while creating record \"%s\": %w", name, err)
 	}
 	return nil
 }
+
+func (r *Route53NS) UpdateRecord(ctx context.Context, name, cname string, ttl int64) error {
+	err := r.changeRecord(ctx, types.ChangeActionUpsert, name, cname, ttl)
+	if err != nil {
+		return fmt.Errorf("error while updating record \"%s\": %w", name, err)
+	}
+	return nil
+}
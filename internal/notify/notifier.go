@@ -0,0 +1,188 @@
+// Package notify posts record changes to webhooks, one notification per
+// reconciliation.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/nomtail/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Maximum number of notifications waiting to be delivered, newer ones are
+// dropped when it's reached.
+const queueSize = 100
+
+var (
+	sentCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bingo_notifications_sent",
+		Help: "The total number of notifications delivered to webhooks",
+	})
+	failureCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bingo_notification_failures",
+		Help: "The total number of notifications that couldn't be delivered to a webhook",
+	})
+)
+
+type EventType = string
+
+const (
+	CreateEvent EventType = "create"
+	DeleteEvent EventType = "delete"
+	UpdateEvent EventType = "update"
+	// A deletion refused because too many records would have been deleted at once.
+	RefusedDeletionEvent EventType = "refused_deletion"
+)
+
+type Event struct {
+	Type   EventType `json:"type"`
+	Domain string    `json:"domain"`
+	// Target of the created or updated record.
+	Target string `json:"target,omitempty"`
+	// Target of the record before the change.
+	PreviousTarget string `json:"previous_target,omitempty"`
+}
+
+// The changes made by a single reconciliation.
+type Batch struct {
+	Backend string    `json:"backend"`
+	Time    time.Time `json:"time"`
+	Events  []Event   `json:"events"`
+}
+
+type Notifier struct {
+	logger     *log.Logger
+	webhooks   []*url.URL
+	template   *template.Template
+	client     *http.Client
+	attempts   int
+	retryDelay time.Duration
+	queue      chan Batch
+}
+
+func NewNotifier(logger *log.Logger, conf config.Notifications) (*Notifier, error) {
+	n := &Notifier{
+		logger:     logger.With("component", "notifier"),
+		client:     &http.Client{Timeout: conf.Timeout},
+		attempts:   conf.Attempts,
+		retryDelay: conf.RetryDelay,
+		queue:      make(chan Batch, queueSize),
+	}
+	for _, rawURL := range conf.WebhookURLs {
+		u, err := url.Parse(string(rawURL))
+		if err != nil {
+			// Leave the URL out of the error
+			if urlErr, ok := err.(*url.Error); ok {
+				err = urlErr.Err
+			}
+			return nil, fmt.Errorf("invalid webhook URL: %w", err)
+		}
+		n.webhooks = append(n.webhooks, u)
+	}
+	if conf.Template != "" {
+		tmpl, err := template.New("webhook").Option("missingkey=error").Funcs(template.FuncMap{
+			"json": toJSON,
+		}).Parse(conf.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template: %w", err)
+		}
+		n.template = tmpl
+	}
+	return n, nil
+}
+
+// JSON encodes a value, so that templates can embed strings safely.
+func toJSON(value any) (string, error) {
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// Queues a batch of changes, it's delivered in the background.
+func (n *Notifier) Notify(batch Batch) {
+	if len(batch.Events) == 0 {
+		return
+	}
+	select {
+	case n.queue <- batch:
+	default:
+		failureCounter.Inc()
+		n.logger.Warn("too many notifications waiting to be delivered, dropping one", "backend", batch.Backend, "events", len(batch.Events))
+	}
+}
+
+// Delivers queued batches to every webhook, in order.
+func (n *Notifier) Run() {
+	for batch := range n.queue {
+		body, err := n.render(batch)
+		if err != nil {
+			failureCounter.Inc()
+			n.logger.Error("error rendering notification", "backend", batch.Backend, "err", err)
+			continue
+		}
+		for _, webhook := range n.webhooks {
+			n.deliver(webhook, body)
+		}
+	}
+}
+
+func (n *Notifier) render(batch Batch) ([]byte, error) {
+	if n.template == nil {
+		return json.Marshal(batch)
+	}
+	buf := &bytes.Buffer{}
+	if err := n.template.Execute(buf, batch); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Posts the body to the webhook, attempting again with exponential backoff
+// when it fails. Only the webhook host is logged, its URL may contain a token.
+func (n *Notifier) deliver(webhook *url.URL, body []byte) {
+	delay := n.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := n.post(webhook, body)
+		if err == nil {
+			sentCounter.Inc()
+			n.logger.Debug("notification delivered", "host", webhook.Host)
+			return
+		}
+		if attempt >= n.attempts {
+			failureCounter.Inc()
+			n.logger.Error("couldn't deliver notification, giving up", "host", webhook.Host, "attempts", attempt, "err", err)
+			return
+		}
+		n.logger.Warn("couldn't deliver notification, will attempt again", "host", webhook.Host, "next_attempt_in", delay, "err", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (n *Notifier) post(webhook *url.URL, body []byte) error {
+	req, err := http.NewRequest("POST", webhook.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Leave the URL out of the error
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("webhook returned an unexpected status: %s", resp.Status)
+	}
+	return nil
+}
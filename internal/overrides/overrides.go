@@ -0,0 +1,108 @@
+// Package overrides holds domain rules added at runtime through the admin API.
+// They apply to every nameserver backend and are lost on restart.
+package overrides
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/n6g7/bingo/internal/dnsname"
+)
+
+type Overrides struct {
+	mu       sync.RWMutex
+	excluded map[string]bool
+	pins     map[string]string // domain -> target
+}
+
+func New() *Overrides {
+	return &Overrides{
+		excluded: map[string]bool{},
+		pins:     map[string]string{},
+	}
+}
+
+// Stops managing the domain: its record is neither created, changed nor deleted.
+func (o *Overrides) Exclude(domain string) error {
+	domain, err := dnsname.Normalize(domain)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.excluded[domain] = true
+	return nil
+}
+
+func (o *Overrides) Include(domain string) error {
+	domain, err := dnsname.Normalize(domain)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.excluded, domain)
+	return nil
+}
+
+// Points the domain's record at the target, whether or not it serves the domain.
+func (o *Overrides) Pin(domain, target string) error {
+	domain, err := dnsname.Normalize(domain)
+	if err != nil {
+		return err
+	}
+	target, err = dnsname.Normalize(target)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.pins[domain] = target
+	return nil
+}
+
+func (o *Overrides) Unpin(domain string) error {
+	domain, err := dnsname.Normalize(domain)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.pins, domain)
+	return nil
+}
+
+// Returns whether the domain is excluded. The domain must be normalized.
+func (o *Overrides) Excluded(domain string) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return o.excluded[domain]
+}
+
+// Returns the target the domain is pinned to, if any. The domain must be
+// normalized.
+func (o *Overrides) PinnedTarget(domain string) (string, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	target, ok := o.pins[domain]
+	return target, ok
+}
+
+type Snapshot struct {
+	Excluded []string          `json:"excluded"`
+	Pins     map[string]string `json:"pins"`
+}
+
+func (o *Overrides) Snapshot() Snapshot {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	snapshot := Snapshot{Excluded: []string{}, Pins: map[string]string{}}
+	for domain := range o.excluded {
+		snapshot.Excluded = append(snapshot.Excluded, domain)
+	}
+	sort.Strings(snapshot.Excluded)
+	for domain, target := range o.pins {
+		snapshot.Pins[domain] = target
+	}
+	return snapshot
+}
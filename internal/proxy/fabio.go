@@ -1,40 +1,71 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"net/http"
 
 	"github.com/n6g7/bingo/internal/config"
-	"golang.org/x/exp/slices"
+	"github.com/n6g7/bingo/internal/discovery"
+	"github.com/n6g7/nomtail/pkg/log"
 )
 
 type FabioProxy struct {
-	hosts     []string
-	adminPort uint16
-	scheme    string
+	logger        *log.Logger
+	hosts         *hostSet
+	adminPort     uint16
+	scheme        string
+	routes        *routeTable
+	hostTemplate  config.HostTemplateConf
+	serviceDomain string
+	template      *hostTemplate
 }
 
-func NewFabioProxy(conf config.FabioConf) *FabioProxy {
+// The name identifies the source in logs and metrics, several Fabio sources can
+// be configured.
+func NewFabioProxy(logger *log.Logger, name string, conf config.FabioConf, serviceDomain string, discoverer discovery.Discoverer) *FabioProxy {
+	logger = logger.With("component", "fabio", "source", name)
 	return &FabioProxy{
-		hosts:     conf.Hosts,
-		adminPort: conf.AdminPort,
-		scheme:    conf.Scheme,
+		logger:        logger,
+		hosts:         newHostSet(logger, name, discoverer),
+		adminPort:     conf.AdminPort,
+		scheme:        conf.Scheme,
+		routes:        newRouteTable(),
+		hostTemplate:  conf.HostTemplate,
+		serviceDomain: serviceDomain,
 	}
 }
 
 func (f *FabioProxy) Init() error {
+	if f.hostTemplate.Enabled {
+		tmpl, err := newHostTemplate(f.hostTemplate.Template, f.serviceDomain)
+		if err != nil {
+			return err
+		}
+		f.template = tmpl
+	}
+
+	err := f.DiscoverHosts()
+	if err != nil {
+		return err
+	}
+
 	// Test connection
-	_, err := f.ListServices()
+	_, err = f.ListServices(context.Background())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (f *FabioProxy) randomHost() string {
-	return f.hosts[rand.Intn(len(f.hosts))]
+func (f *FabioProxy) DiscoverHosts() error {
+	err := f.hosts.discover()
+	if err != nil {
+		return err
+	}
+	f.routes.retain(f.hosts.list())
+	return nil
 }
 
 type FabioService struct {
@@ -50,14 +81,22 @@ type FabioService struct {
 	Pct99   uint    `json:"pct99"`
 }
 
-func (f *FabioProxy) ListServices() ([]Service, error) {
-	host := f.randomHost()
+func (f *FabioProxy) ListServices(ctx context.Context) ([]Service, error) {
+	return f.routes.collect(ctx, f.logger, f.hosts.name, f.hosts.list(), f.listHostServices)
+}
+
+func (f *FabioProxy) listHostServices(ctx context.Context, host string) ([]Service, error) {
 	port := fmt.Sprintf("%d", f.adminPort)
 	url := f.scheme + "://" + host + ":" + port + "/api/routes"
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error querying Fabio routes: %w", err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("fabio returned an unexpected status code: %d", resp.StatusCode)
 	}
@@ -72,22 +111,36 @@ func (f *FabioProxy) ListServices() ([]Service, error) {
 
 	services := []Service{}
 	for _, service := range output {
-		if service.Host == "" {
-			continue
+		domain := service.Host
+		if domain == "" {
+			// Path-based route, generate a domain from the service name if enabled
+			if f.template == nil {
+				continue
+			}
+			domain, err = f.template.render(service.Service)
+			if err != nil {
+				return nil, err
+			}
+			if domain == "" {
+				continue
+			}
 		}
 		services = append(services, Service{
 			Name:   service.Service,
-			Domain: service.Host,
+			Domain: domain,
 		})
 	}
 
 	return services, nil
 }
 
-func (f *FabioProxy) GetTarget(sourceDomain string) string {
-	return f.randomHost()
+func (f *FabioProxy) GetTarget(sourceDomain string, policy config.TargetPolicy) string {
+	if host := f.routes.pick(sourceDomain, policy); host != "" {
+		return host
+	}
+	return f.hosts.pick(sourceDomain, policy)
 }
 
-func (f *FabioProxy) IsValidTarget(target string) bool {
-	return slices.Contains(f.hosts, target)
+func (f *FabioProxy) IsValidTarget(domain, target string) bool {
+	return f.hosts.contains(target) && f.routes.serves(domain, target)
 }
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/discovery"
+	"github.com/n6g7/nomtail/pkg/log"
+)
+
+func TestFabioRoutes(t *testing.T) {
+	routes := []FabioService{
+		{Service: "app", Host: "app.svc.local", Path: "/"},
+		{Service: "app", Host: "app.svc.local", Path: "/api"},
+		{Service: "api", Host: "API.svc.local", Path: "/"},
+		{Service: "My_App", Path: "/myapp"},
+		{Service: "___", Path: "/nothing"},
+	}
+	tests := []struct {
+		generate bool
+		want     []string
+		listed   []string // deduplicated and normalized by the route table
+	}{
+		{false, []string{"app.svc.local", "app.svc.local", "API.svc.local"}, []string{"app.svc.local", "api.svc.local"}},
+		{true, []string{"app.svc.local", "app.svc.local", "API.svc.local", "my-app.svc.local"}, []string{"app.svc.local", "api.svc.local", "my-app.svc.local"}},
+	}
+	for _, tt := range tests {
+		host, port := serveJSON(t, routes)
+		conf := config.FabioConf{
+			AdminPort:    port,
+			Scheme:       "http",
+			HostTemplate: config.HostTemplateConf{Enabled: tt.generate, Template: "{{.Service}}.{{.ServiceDomain}}"},
+		}
+		fabio := NewFabioProxy(log.SetupLogger(), "fabio", conf, "svc.local", discovery.NewStaticDiscoverer([]string{host}))
+		if err := fabio.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		services, err := fabio.listHostServices(context.Background(), host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := domains(services); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("generate = %t: domains %q, want %q", tt.generate, got, tt.want)
+		}
+
+		services, err = fabio.ListServices(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := domains(services); !reflect.DeepEqual(got, tt.listed) {
+			t.Errorf("generate = %t: listed domains %q, want %q", tt.generate, got, tt.listed)
+		}
+	}
+}
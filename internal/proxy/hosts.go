@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/discovery"
+	"github.com/n6g7/bingo/internal/dnsname"
+	"github.com/n6g7/nomtail/pkg/log"
+	"golang.org/x/exp/slices"
+)
+
+// The list of proxy hosts, refreshed from a discoverer.
+type hostSet struct {
+	mu         sync.RWMutex
+	logger     *log.Logger
+	name       string
+	discoverer discovery.Discoverer
+	hosts      []string
+}
+
+func newHostSet(logger *log.Logger, name string, discoverer discovery.Discoverer) *hostSet {
+	return &hostSet{
+		logger:     logger,
+		name:       name,
+		discoverer: discoverer,
+		hosts:      []string{},
+	}
+}
+
+func (hs *hostSet) discover() error {
+	start := time.Now()
+	discovered, err := hs.discoverer.Discover()
+	observe(hs.name, "", "discover_hosts", start, err)
+	if err != nil {
+		return fmt.Errorf("proxy host discovery failed: %w", err)
+	}
+	hosts := []string{}
+	for _, host := range discovered {
+		normalized, err := dnsname.Normalize(host)
+		if err != nil {
+			hs.logger.Warn("ignoring invalid proxy host", "err", err)
+			continue
+		}
+		hosts = append(hosts, normalized)
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("proxy host discovery returned no hosts")
+	}
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	if !slices.Equal(hosts, hs.hosts) {
+		hs.logger.Info("proxy hosts changed", "hosts", hosts, "previous", hs.hosts)
+	}
+	hs.hosts = hosts
+	return nil
+}
+
+func (hs *hostSet) list() []string {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return hs.hosts
+}
+
+func (hs *hostSet) pick(domain string, policy config.TargetPolicy) string {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return choose(hs.hosts, domain, policy)
+}
+
+func (hs *hostSet) contains(host string) bool {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return slices.Contains(hs.hosts, host)
+}
@@ -1,13 +1,27 @@
 package proxy
 
+import (
+	"context"
+
+	"github.com/n6g7/bingo/internal/config"
+)
+
 type Service struct {
-	Name   string
-	Domain string
+	Name   string `json:"name"`
+	Domain string `json:"domain"`
+	// Name of the router exposing the service, for proxies that have routers.
+	Router string `json:"router,omitempty"`
+	// Name of the proxy source serving the domain.
+	Source string `json:"source"`
 }
 
 type Proxy interface {
 	Init() error
-	ListServices() ([]Service, error)
-	GetTarget(sourceDomain string) string
-	IsValidTarget(target string) bool
+	// Refreshes the list of proxy hosts.
+	DiscoverHosts() error
+	ListServices(ctx context.Context) ([]Service, error)
+	// Returns a proxy host serving the domain, picked according to the policy.
+	GetTarget(sourceDomain string, policy config.TargetPolicy) string
+	// Returns whether target is a proxy host currently serving the domain.
+	IsValidTarget(domain, target string) bool
 }
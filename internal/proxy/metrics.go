@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bingo_proxy_request_duration_seconds",
+		Help:    "Duration of proxy requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"proxy", "host", "operation"})
+	requestErrorCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bingo_proxy_request_errors",
+		Help: "The total number of failed proxy requests",
+	}, []string{"proxy", "host", "operation"})
+	lastSuccessGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_proxy_last_success_timestamp_seconds",
+		Help: "When a proxy request last succeeded, as a Unix timestamp",
+	}, []string{"proxy", "host", "operation"})
+)
+
+// Records the duration and outcome of a request to a proxy host. The host is
+// empty for host discovery.
+func observe(proxy, host, operation string, start time.Time, err error) {
+	requestDuration.WithLabelValues(proxy, host, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		requestErrorCounter.WithLabelValues(proxy, host, operation).Inc()
+		return
+	}
+	lastSuccessGauge.WithLabelValues(proxy, host, operation).SetToCurrentTime()
+}
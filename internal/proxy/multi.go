@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/tracing"
+	"github.com/n6g7/nomtail/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/exp/slices"
+)
+
+var conflictsGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "bingo_proxy_conflicts",
+	Help: "The number of domains served by more than one proxy source",
+})
+
+type Source struct {
+	Name     string
+	Priority int
+	Proxy    Proxy
+}
+
+type sourceState struct {
+	Source
+	services []Service // last successfully listed services
+}
+
+// Merges the services of several proxy sources into a single Proxy.
+// When a domain is served by more than one source, the source with the
+// highest priority wins (ties go to the first configured source).
+type MultiProxy struct {
+	logger    *log.Logger
+	mu        sync.RWMutex
+	sources   []*sourceState
+	owners    map[string]*sourceState // domain -> source supplying its target
+	conflicts mapset.Set[string]
+	services  []Service // last merged services
+}
+
+func NewMultiProxy(logger *log.Logger, sources []Source) *MultiProxy {
+	return &MultiProxy{
+		logger:    logger.With("component", "proxy"),
+		sources:   sortSources(sources, nil),
+		owners:    map[string]*sourceState{},
+		conflicts: mapset.NewSet[string](),
+	}
+}
+
+// Sorts sources by priority, reusing the previous state of sources whose
+// proxy didn't change.
+func sortSources(sources []Source, previous []*sourceState) []*sourceState {
+	states := []*sourceState{}
+	for _, source := range sources {
+		state := &sourceState{Source: source}
+		for _, p := range previous {
+			if p.Proxy == source.Proxy {
+				state.services = p.services
+			}
+		}
+		states = append(states, state)
+	}
+	// Stable so that configuration order breaks ties.
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].Priority > states[j].Priority
+	})
+	return states
+}
+
+// Replaces the sources, eg. when the config is reloaded. New sources must be
+// initialized already. Sources whose proxy is unchanged keep contributing
+// their last listed services if they can't be reached.
+// Must not be called concurrently with ListServices.
+func (m *MultiProxy) SetSources(sources []Source) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sources = sortSources(sources, m.sources)
+	m.owners = map[string]*sourceState{}
+}
+
+func (m *MultiProxy) sourceList() []*sourceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sources
+}
+
+func (m *MultiProxy) Init() error {
+	for _, source := range m.sourceList() {
+		err := source.Proxy.Init()
+		if err != nil {
+			return fmt.Errorf("%s: %w", source.Name, err)
+		}
+		m.logger.Info("initialized proxy source", "source", source.Name, "priority", source.Priority)
+	}
+	return nil
+}
+
+func (m *MultiProxy) DiscoverHosts() error {
+	var errs []error
+	for _, source := range m.sourceList() {
+		err := source.Proxy.DiscoverHosts()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("host discovery failed for %d source(s): %v", len(errs), errs)
+	}
+	return nil
+}
+
+// Lists services from every source. A source that can't be reached keeps
+// contributing the services it last listed, so that its domains aren't deleted
+// during an outage.
+func (m *MultiProxy) ListServices(ctx context.Context) ([]Service, error) {
+	sources := m.sourceList()
+	for _, source := range sources {
+		sourceCtx, span := tracer.Start(ctx, "proxy.list_services", trace.WithAttributes(attribute.String("source", source.Name)))
+		services, err := source.Proxy.ListServices(sourceCtx)
+		tracing.End(span, err)
+		if err != nil {
+			if source.services == nil {
+				return nil, fmt.Errorf("%s: %w", source.Name, err)
+			}
+			m.logger.Error("error loading services from proxy source, using last known services", "source", source.Name, "err", err)
+			continue
+		}
+		for i := range services {
+			services[i].Source = source.Name
+		}
+		source.services = services
+	}
+
+	owners := map[string]*sourceState{}
+	conflicts := mapset.NewSet[string]()
+	merged := []Service{}
+	for _, source := range sources {
+		for _, service := range source.services {
+			if owner, ok := owners[service.Domain]; ok {
+				if owner != source {
+					conflicts.Add(service.Domain)
+					if !m.conflicts.Contains(service.Domain) {
+						m.logger.Warn(
+							"domain served by several proxy sources, using the highest priority one",
+							"domain", service.Domain,
+							"source", owner.Name,
+							"ignored_source", source.Name,
+						)
+					}
+				}
+				continue
+			}
+			owners[service.Domain] = source
+			merged = append(merged, service)
+		}
+	}
+	conflictsGauge.Set(float64(conflicts.Cardinality()))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.owners = owners
+	m.conflicts = conflicts
+	m.services = merged
+
+	return merged, nil
+}
+
+// Returns the services last listed.
+func (m *MultiProxy) Services() []Service {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.Clone(m.services)
+}
+
+func (m *MultiProxy) owner(domain string) *sourceState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.owners[domain]
+}
+
+func (m *MultiProxy) GetTarget(sourceDomain string, policy config.TargetPolicy) string {
+	owner := m.owner(sourceDomain)
+	if owner == nil {
+		return m.sourceList()[0].Proxy.GetTarget(sourceDomain, policy)
+	}
+	return owner.Proxy.GetTarget(sourceDomain, policy)
+}
+
+func (m *MultiProxy) IsValidTarget(domain, target string) bool {
+	owner := m.owner(domain)
+	if owner == nil {
+		// Not served by any source, the reconciler will delete it anyway.
+		for _, source := range m.sourceList() {
+			if source.Proxy.IsValidTarget(domain, target) {
+				return true
+			}
+		}
+		return false
+	}
+	return owner.Proxy.IsValidTarget(domain, target)
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/nomtail/pkg/log"
+	"golang.org/x/exp/slices"
+)
+
+// A proxy whose every domain is served by a single host.
+type fakeProxy struct {
+	host     string
+	services []Service
+	err      error
+}
+
+func (f *fakeProxy) Init() error {
+	return nil
+}
+
+func (f *fakeProxy) DiscoverHosts() error {
+	return nil
+}
+
+func (f *fakeProxy) ListServices(ctx context.Context) ([]Service, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	// Sources are stamped on the returned services
+	return slices.Clone(f.services), nil
+}
+
+func (f *fakeProxy) GetTarget(sourceDomain string, policy config.TargetPolicy) string {
+	return f.host
+}
+
+func (f *fakeProxy) IsValidTarget(domain, target string) bool {
+	return target == f.host
+}
+
+func servicesFor(domains ...string) []Service {
+	services := []Service{}
+	for _, domain := range domains {
+		services = append(services, Service{Name: domain, Domain: domain})
+	}
+	return services
+}
+
+// Domain -> source supplying it.
+func sourcesByDomain(services []Service) map[string]string {
+	sources := map[string]string{}
+	for _, service := range services {
+		sources[service.Domain] = service.Source
+	}
+	return sources
+}
+
+func TestMultiProxyPriority(t *testing.T) {
+	low := &fakeProxy{host: "low.lan", services: servicesFor("app.svc.local", "api.svc.local")}
+	high := &fakeProxy{host: "high.lan", services: servicesFor("app.svc.local", "web.svc.local")}
+	tie := &fakeProxy{host: "tie.lan", services: servicesFor("api.svc.local")}
+	m := NewMultiProxy(log.SetupLogger(), []Source{
+		{Name: "low", Priority: 0, Proxy: low},
+		{Name: "high", Priority: 10, Proxy: high},
+		{Name: "tie", Priority: 0, Proxy: tie},
+	})
+
+	services, err := m.ListServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"app.svc.local": "high",
+		"web.svc.local": "high",
+		// Ties go to the first configured source
+		"api.svc.local": "low",
+	}
+	if got := sourcesByDomain(services); !reflect.DeepEqual(got, want) {
+		t.Errorf("domain sources = %v, want %v", got, want)
+	}
+
+	conflicts := m.conflicts.ToSlice()
+	sort.Strings(conflicts)
+	if !reflect.DeepEqual(conflicts, []string{"api.svc.local", "app.svc.local"}) {
+		t.Errorf("conflicts = %v", conflicts)
+	}
+
+	targets := []struct {
+		domain string
+		target string
+		valid  bool
+	}{
+		{"app.svc.local", "high.lan", true},
+		{"app.svc.local", "low.lan", false},
+		{"api.svc.local", "low.lan", true},
+		{"api.svc.local", "tie.lan", false},
+		// Domains no source serves are valid targets of any source
+		{"gone.svc.local", "tie.lan", true},
+		{"gone.svc.local", "other.lan", false},
+	}
+	for _, tt := range targets {
+		if got := m.IsValidTarget(tt.domain, tt.target); got != tt.valid {
+			t.Errorf("IsValidTarget(%s, %s) = %t, want %t", tt.domain, tt.target, got, tt.valid)
+		}
+	}
+	if target := m.GetTarget("app.svc.local", config.RandomTarget); target != "high.lan" {
+		t.Errorf("GetTarget(app.svc.local) = %s, want high.lan", target)
+	}
+}
+
+func TestMultiProxyUnreachableSource(t *testing.T) {
+	primary := &fakeProxy{host: "primary.lan", services: servicesFor("app.svc.local")}
+	secondary := &fakeProxy{host: "secondary.lan", services: servicesFor("api.svc.local")}
+	m := NewMultiProxy(log.SetupLogger(), []Source{
+		{Name: "primary", Priority: 10, Proxy: primary},
+		{Name: "secondary", Proxy: secondary},
+	})
+	if _, err := m.ListServices(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last listed services are kept during an outage
+	primary.err = errors.New("connection refused")
+	services, err := m.ListServices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"app.svc.local": "primary", "api.svc.local": "secondary"}
+	if got := sourcesByDomain(services); !reflect.DeepEqual(got, want) {
+		t.Errorf("domain sources during an outage = %v, want %v", got, want)
+	}
+
+	// A source that never listed services fails the listing
+	m = NewMultiProxy(log.SetupLogger(), []Source{{Name: "primary", Proxy: primary}})
+	if _, err := m.ListServices(context.Background()); err == nil {
+		t.Errorf("ListServices() returned no error for a source that never listed services")
+	}
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/dnsname"
+	"github.com/n6g7/bingo/internal/tracing"
+	"github.com/n6g7/nomtail/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/exp/slices"
+)
+
+var tracer = otel.Tracer("github.com/n6g7/bingo/internal/proxy")
+
+// Client for proxy APIs, recording a span for each request.
+var httpClient = &http.Client{Transport: tracing.Transport(http.DefaultTransport)}
+
+var invalidDomainsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "bingo_proxy_invalid_domains",
+	Help: "The number of proxy domains ignored because they aren't valid host names",
+}, []string{"proxy"})
+
+// Picks one of the hosts for the domain according to the target policy.
+func choose(hosts []string, domain string, policy config.TargetPolicy) string {
+	if len(hosts) == 0 {
+		return ""
+	}
+	if policy == config.HashTarget {
+		sorted := slices.Clone(hosts)
+		sort.Strings(sorted)
+		h := fnv.New32a()
+		h.Write([]byte(domain))
+		return sorted[h.Sum32()%uint32(len(sorted))]
+	}
+	return hosts[rand.Intn(len(hosts))]
+}
+
+// Keeps track of which proxy hosts advertise each domain.
+type routeTable struct {
+	mu      sync.RWMutex
+	routes  map[string]mapset.Set[string] // domain -> hosts
+	invalid mapset.Set[string]            // invalid domains already reported
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{
+		routes:  map[string]mapset.Set[string]{},
+		invalid: mapset.NewSet[string](),
+	}
+}
+
+// Replaces the routes, and the invalid domains reported while collecting them.
+func (rt *routeTable) set(routes map[string]mapset.Set[string], invalid mapset.Set[string]) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.routes = routes
+	rt.invalid = invalid
+}
+
+// Returns the invalid domains reported by the last collection.
+func (rt *routeTable) reported() mapset.Set[string] {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.invalid
+}
+
+// Forgets about hosts that aren't part of the given list anymore.
+func (rt *routeTable) retain(hosts []string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	current := mapset.NewSet[string](hosts...)
+	for domain, domainHosts := range rt.routes {
+		rt.routes[domain] = domainHosts.Intersect(current)
+	}
+}
+
+// Returns a host serving the domain, or an empty string if no host is known to
+// serve it.
+func (rt *routeTable) pick(domain string, policy config.TargetPolicy) string {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	hosts, ok := rt.routes[domain]
+	if !ok {
+		return ""
+	}
+	return choose(hosts.ToSlice(), domain, policy)
+}
+
+// Returns whether the host serves the domain. Domains no host advertises are
+// considered served by every host, the reconciler will delete them anyway.
+func (rt *routeTable) serves(domain, host string) bool {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	hosts, ok := rt.routes[domain]
+	if !ok {
+		return true
+	}
+	return hosts.Contains(host)
+}
+
+// Queries every host with the fetch function, records which hosts serve each
+// domain and returns the deduplicated list of services, with normalized domains.
+// Services with an invalid domain are skipped and reported.
+// Hosts that can't be reached are skipped (they won't be picked as targets),
+// an error is only returned if no host could be queried.
+func (rt *routeTable) collect(ctx context.Context, logger *log.Logger, name string, hosts []string, fetch func(ctx context.Context, host string) ([]Service, error)) ([]Service, error) {
+	routes := map[string]mapset.Set[string]{}
+	services := []Service{}
+	invalid := mapset.NewSet[string]()
+	reported := rt.reported()
+	var lastErr error
+	reached := 0
+
+	for _, host := range hosts {
+		start := time.Now()
+		hostServices, err := fetch(ctx, host)
+		observe(name, host, "list_services", start, err)
+		if err != nil {
+			logger.Warn("failed to list services from proxy host", "host", host, "err", err)
+			lastErr = err
+			continue
+		}
+		reached++
+
+		for _, service := range hostServices {
+			domain, err := dnsname.Normalize(service.Domain)
+			if err != nil {
+				invalid.Add(service.Domain)
+				if !reported.Contains(service.Domain) {
+					logger.Warn("ignoring service with invalid domain", "service", service.Name, "err", err)
+				}
+				continue
+			}
+			service.Domain = domain
+
+			if _, ok := routes[service.Domain]; !ok {
+				routes[service.Domain] = mapset.NewSet[string]()
+				services = append(services, service)
+			}
+			routes[service.Domain].Add(host)
+		}
+	}
+
+	if reached == 0 {
+		if lastErr == nil {
+			return nil, fmt.Errorf("no proxy host configured")
+		}
+		return nil, lastErr
+	}
+
+	invalidDomainsGauge.WithLabelValues(name).Set(float64(invalid.Cardinality()))
+	rt.set(routes, invalid)
+	return services, nil
+}
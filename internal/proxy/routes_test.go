@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/nomtail/pkg/log"
+)
+
+// Returns a fetch function serving these services per host, hosts missing from
+// the map can't be reached.
+func fakeFetch(routes map[string][]Service) func(ctx context.Context, host string) ([]Service, error) {
+	return func(ctx context.Context, host string) ([]Service, error) {
+		services, ok := routes[host]
+		if !ok {
+			return nil, errors.New("connection refused")
+		}
+		return services, nil
+	}
+}
+
+func TestRouteTableCollect(t *testing.T) {
+	rt := newRouteTable()
+	fetch := fakeFetch(map[string][]Service{
+		"a.lan": {
+			{Name: "app", Domain: "app.svc.local"},
+			{Name: "api", Domain: "API.svc.local."},
+			{Name: "bad", Domain: "bad_domain!.svc.local"},
+		},
+		"b.lan": {
+			{Name: "app", Domain: "app.svc.local"},
+		},
+	})
+
+	services, err := rt.collect(context.Background(), log.SetupLogger(), "test", []string{"a.lan", "b.lan", "down.lan"}, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := domains(services); !reflect.DeepEqual(got, []string{"app.svc.local", "api.svc.local"}) {
+		t.Errorf("collected domains %q", got)
+	}
+
+	served := []struct {
+		domain string
+		host   string
+		want   bool
+	}{
+		{"app.svc.local", "a.lan", true},
+		{"app.svc.local", "b.lan", true},
+		{"api.svc.local", "a.lan", true},
+		{"api.svc.local", "b.lan", false},
+		{"app.svc.local", "down.lan", false},
+		// Unknown domains are served by every host
+		{"gone.svc.local", "b.lan", true},
+	}
+	for _, tt := range served {
+		if got := rt.serves(tt.domain, tt.host); got != tt.want {
+			t.Errorf("serves(%s, %s) = %t, want %t", tt.domain, tt.host, got, tt.want)
+		}
+	}
+	if host := rt.pick("api.svc.local", config.HashTarget); host != "a.lan" {
+		t.Errorf("pick(api.svc.local) = %s, want a.lan", host)
+	}
+	if reported := rt.reported(); !reported.Equal(mapset.NewSet("bad_domain!.svc.local")) {
+		t.Errorf("reported invalid domains %v", reported)
+	}
+
+	// Hosts that went away are forgotten
+	rt.retain([]string{"b.lan"})
+	if rt.serves("app.svc.local", "a.lan") || !rt.serves("app.svc.local", "b.lan") {
+		t.Errorf("a.lan still serves app.svc.local after being removed")
+	}
+}
+
+func TestRouteTableCollectErrors(t *testing.T) {
+	rt := newRouteTable()
+	fetch := fakeFetch(map[string][]Service{})
+
+	if _, err := rt.collect(context.Background(), log.SetupLogger(), "test", []string{}, fetch); err == nil {
+		t.Errorf("collect() without hosts returned no error")
+	}
+	if _, err := rt.collect(context.Background(), log.SetupLogger(), "test", []string{"down.lan"}, fetch); err == nil || err.Error() != "connection refused" {
+		t.Errorf("collect() without reachable hosts returned %v, want the last host's error", err)
+	}
+}
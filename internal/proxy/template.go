@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+var invalidLabelChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// Turns a service name into a valid DNS label: lowercase letters, digits and
+// hyphens only, no leading or trailing hyphen, at most 63 characters.
+func sanitizeLabel(name string) string {
+	label := invalidLabelChars.ReplaceAllString(strings.ToLower(name), "-")
+	label = strings.Trim(label, "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+type hostTemplateData struct {
+	Service       string
+	ServiceDomain string
+}
+
+// Generates domains for services whose routes don't declare a host.
+type hostTemplate struct {
+	template      *template.Template
+	serviceDomain string
+}
+
+func newHostTemplate(text, serviceDomain string) (*hostTemplate, error) {
+	tmpl, err := template.New("host").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host template: %w", err)
+	}
+	return &hostTemplate{
+		template:      tmpl,
+		serviceDomain: serviceDomain,
+	}, nil
+}
+
+// Returns the generated domain for the service, or an empty string if the
+// service name doesn't contain any valid character.
+func (ht *hostTemplate) render(serviceName string) (string, error) {
+	label := sanitizeLabel(serviceName)
+	if label == "" {
+		return "", nil
+	}
+
+	buf := &strings.Builder{}
+	err := ht.template.Execute(buf, hostTemplateData{
+		Service:       label,
+		ServiceDomain: ht.serviceDomain,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error rendering host template for service \"%s\": %w", serviceName, err)
+	}
+	return buf.String(), nil
+}
@@ -1,44 +1,73 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"net/http"
 	"regexp"
 	"strings"
 
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/n6g7/bingo/internal/config"
-	"golang.org/x/exp/slices"
+	"github.com/n6g7/bingo/internal/discovery"
+	"github.com/n6g7/nomtail/pkg/log"
 )
 
 type TraefikProxy struct {
-	hosts       []string
+	logger      *log.Logger
+	hosts       *hostSet
 	adminPort   uint16
 	scheme      string
 	entryPoints mapset.Set[string]
 	regexp      *regexp.Regexp
+	routes      *routeTable
+
+	hostTemplate  config.HostTemplateConf
+	serviceDomain string
+	template      *hostTemplate
 }
 
-func NewTraefikProxy(conf config.TraefikConf) *TraefikProxy {
+// The name identifies the source in logs and metrics, several Traefik sources
+// can be configured.
+func NewTraefikProxy(logger *log.Logger, name string, conf config.TraefikConf, serviceDomain string, discoverer discovery.Discoverer) *TraefikProxy {
+	logger = logger.With("component", "traefik", "source", name)
 	return &TraefikProxy{
-		hosts:       conf.Hosts,
+		logger:      logger,
+		hosts:       newHostSet(logger, name, discoverer),
 		adminPort:   conf.AdminPort,
 		scheme:      conf.Scheme,
 		entryPoints: mapset.NewSet[string](conf.EntryPoints...),
+		routes:      newRouteTable(),
+
+		hostTemplate:  conf.HostTemplate,
+		serviceDomain: serviceDomain,
 	}
 }
 
 func (t *TraefikProxy) Init() error {
-	re, err := regexp.Compile("^Host\\((`[a-z0-9.-]+`(, `[a-z0-9.-]+`)*)\\)$")
+	// Any host is captured, invalid ones are reported when collecting routes
+	re, err := regexp.Compile("^Host\\((`[^`]*`(\\s*,\\s*`[^`]*`)*)\\)$")
 	if err != nil {
 		return err
 	}
 	t.regexp = re
 
+	if t.hostTemplate.Enabled {
+		tmpl, err := newHostTemplate(t.hostTemplate.Template, t.serviceDomain)
+		if err != nil {
+			return err
+		}
+		t.template = tmpl
+	}
+
+	err = t.DiscoverHosts()
+	if err != nil {
+		return err
+	}
+
 	// Test connection
-	_, err = t.ListServices()
+	_, err = t.ListServices(context.Background())
 	if err != nil {
 		return err
 	}
@@ -46,8 +75,13 @@ func (t *TraefikProxy) Init() error {
 	return nil
 }
 
-func (t *TraefikProxy) randomHost() string {
-	return t.hosts[rand.Intn(len(t.hosts))]
+func (t *TraefikProxy) DiscoverHosts() error {
+	err := t.hosts.discover()
+	if err != nil {
+		return err
+	}
+	t.routes.retain(t.hosts.list())
+	return nil
 }
 
 type TraefikRouter struct {
@@ -59,14 +93,22 @@ type TraefikRouter struct {
 	EntryPoints []string `json:"entryPoints"`
 }
 
-func (t *TraefikProxy) ListServices() ([]Service, error) {
-	host := t.randomHost()
+func (t *TraefikProxy) ListServices(ctx context.Context) ([]Service, error) {
+	return t.routes.collect(ctx, t.logger, t.hosts.name, t.hosts.list(), t.listHostServices)
+}
+
+func (t *TraefikProxy) listHostServices(ctx context.Context, host string) ([]Service, error) {
 	port := fmt.Sprintf("%d", t.adminPort)
 	url := t.scheme + "://" + host + ":" + port + "/api/http/routers"
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error querying Traefik services: %w", err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("traefik returned an unexpected status code: %d", resp.StatusCode)
 	}
@@ -94,15 +136,34 @@ func (t *TraefikProxy) ListServices() ([]Service, error) {
 		match := t.regexp.FindStringSubmatch(router.Rule)
 
 		if len(match) < 2 {
+			// Rule without any host, generate a domain from the service name if enabled
+			if t.template == nil || strings.Contains(router.Rule, "Host") {
+				continue
+			}
+			// Service names are suffixed with their provider (eg. "myapp@docker")
+			name, _, _ := strings.Cut(router.Service, "@")
+			domain, err := t.template.render(name)
+			if err != nil {
+				return nil, err
+			}
+			if domain != "" {
+				services = append(services, Service{
+					Name:   router.Service,
+					Domain: domain,
+					Router: router.Name,
+				})
+			}
 			continue
 		}
 
-		items := strings.Split(match[1], ",")
-		for _, item := range items {
-			domain := strings.Trim(item, " `")
+		// Hosts are every other item, between backquotes
+		items := strings.Split(match[1], "`")
+		for i := 1; i < len(items); i += 2 {
+			domain := items[i]
 			services = append(services, Service{
 				Name:   router.Service,
 				Domain: domain,
+				Router: router.Name,
 			})
 		}
 	}
@@ -110,10 +171,13 @@ func (t *TraefikProxy) ListServices() ([]Service, error) {
 	return services, nil
 }
 
-func (t *TraefikProxy) GetTarget(sourceDomain string) string {
-	return t.randomHost()
+func (t *TraefikProxy) GetTarget(sourceDomain string, policy config.TargetPolicy) string {
+	if host := t.routes.pick(sourceDomain, policy); host != "" {
+		return host
+	}
+	return t.hosts.pick(sourceDomain, policy)
 }
 
-func (t *TraefikProxy) IsValidTarget(target string) bool {
-	return slices.Contains(t.hosts, target)
+func (t *TraefikProxy) IsValidTarget(domain, target string) bool {
+	return t.hosts.contains(target) && t.routes.serves(domain, target)
 }
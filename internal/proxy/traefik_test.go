@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/discovery"
+	"github.com/n6g7/nomtail/pkg/log"
+)
+
+// Serves body as JSON, returns the host and port to reach it.
+func serveJSON(t *testing.T, body any) (string, uint16) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(body)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(u.Port(), 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Hostname(), uint16(port)
+}
+
+func domains(services []Service) []string {
+	list := []string{}
+	for _, service := range services {
+		list = append(list, service.Domain)
+	}
+	return list
+}
+
+func TestTraefikRules(t *testing.T) {
+	tests := []struct {
+		rule     string
+		generate bool
+		want     []string
+	}{
+		{"Host(`app.svc.local`)", false, []string{"app.svc.local"}},
+		{"Host(`app.svc.local`, `api.svc.local`)", false, []string{"app.svc.local", "api.svc.local"}},
+		{"Host(`app.svc.local`,`api.svc.local`)", false, []string{"app.svc.local", "api.svc.local"}},
+		// Invalid hosts are captured, to be reported when collecting routes
+		{"Host(`app_1.svc.local`)", false, []string{"app_1.svc.local"}},
+		{"Host(``)", false, []string{""}},
+		{"Host(`app.svc.local`) && PathPrefix(`/api`)", false, []string{}},
+		{"Host(`app.svc.local`) && PathPrefix(`/api`)", true, []string{}},
+		{"HostRegexp(`{name:.+}.svc.local`)", true, []string{}},
+		{"PathPrefix(`/myapp`)", false, []string{}},
+		{"PathPrefix(`/myapp`)", true, []string{"my-app.svc.local"}},
+	}
+	for _, tt := range tests {
+		routers := []TraefikRouter{{Name: "myapp@docker", Status: "enabled", Rule: tt.rule, Service: "my_app@docker", EntryPoints: []string{"web"}}}
+		host, port := serveJSON(t, routers)
+		conf := config.TraefikConf{
+			AdminPort:    port,
+			Scheme:       "http",
+			EntryPoints:  []string{"web"},
+			HostTemplate: config.HostTemplateConf{Enabled: tt.generate, Template: "{{.Service}}.{{.ServiceDomain}}"},
+		}
+		traefik := NewTraefikProxy(log.SetupLogger(), "traefik", conf, "svc.local", discovery.NewStaticDiscoverer([]string{host}))
+		if err := traefik.Init(); err != nil {
+			t.Fatal(err)
+		}
+
+		services, err := traefik.listHostServices(context.Background(), host)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.rule, err)
+		}
+		if got := domains(services); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("rule %s (generate = %t): domains %q, want %q", tt.rule, tt.generate, got, tt.want)
+		}
+		for _, service := range services {
+			if service.Name != "my_app@docker" || service.Router != "myapp@docker" {
+				t.Errorf("rule %s: service %+v", tt.rule, service)
+			}
+		}
+	}
+}
+
+func TestTraefikRouterFilters(t *testing.T) {
+	routers := []TraefikRouter{
+		{Name: "app", Status: "enabled", Rule: "Host(`app.svc.local`)", EntryPoints: []string{"web", "websecure"}},
+		{Name: "disabled", Status: "disabled", Rule: "Host(`disabled.svc.local`)", EntryPoints: []string{"web"}},
+		{Name: "internal", Status: "enabled", Rule: "Host(`internal.svc.local`)", EntryPoints: []string{"traefik"}},
+	}
+	host, port := serveJSON(t, routers)
+	conf := config.TraefikConf{AdminPort: port, Scheme: "http", EntryPoints: []string{"web"}}
+	traefik := NewTraefikProxy(log.SetupLogger(), "traefik", conf, "svc.local", discovery.NewStaticDiscoverer([]string{host}))
+	if err := traefik.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	services, err := traefik.listHostServices(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := domains(services); !reflect.DeepEqual(got, []string{"app.svc.local"}) {
+		t.Errorf("domains %q, want only the enabled router on a tracked entry point", got)
+	}
+}
@@ -0,0 +1,79 @@
+package reconcile
+
+import (
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/audit"
+)
+
+// Returns why each change is needed, by operation and domain.
+// Must be called with the lock held, before the queues are updated.
+func (r *Reconciler) changeReasons(toCreate, toDelete, toUpdate mapset.Set[string]) map[Operation]map[string]audit.Reason {
+	reasons := map[Operation]map[string]audit.Reason{
+		CreateOperation: {},
+		DeleteOperation: {},
+		UpdateOperation: {},
+	}
+	for domain := range toCreate.Iter() {
+		reasons[CreateOperation][domain] = r.recreateReason(domain, audit.NewServiceReason)
+	}
+	for domain := range toDelete.Iter() {
+		reasons[DeleteOperation][domain] = r.recreateReason(domain, audit.VanishedServiceReason)
+	}
+	for domain := range toUpdate.Iter() {
+		switch {
+		case r.retargetQueue.Contains(domain):
+			reasons[UpdateOperation][domain] = audit.AdminReason
+		case r.drift[domain] == TargetDrift:
+			reasons[UpdateOperation][domain] = audit.InvalidTargetReason
+		default:
+			reasons[UpdateOperation][domain] = audit.DriftReason
+		}
+	}
+	return reasons
+}
+
+// Records are deleted and created again when queued for deletion or holding
+// the wrong type of record, otherwise the default reason applies.
+func (r *Reconciler) recreateReason(domain string, otherwise audit.Reason) audit.Reason {
+	switch {
+	case r.deletionQueue.Contains(domain):
+		return audit.AdminReason
+	case r.drift[domain] == TypeDrift:
+		return audit.DriftReason
+	}
+	return otherwise
+}
+
+// Writes the outcome of an attempted change to the audit log.
+func (r *Reconciler) auditChange(operation Operation, domain, oldTarget, newTarget string, reasons map[Operation]map[string]audit.Reason, err error) {
+	result := audit.Success
+	if err != nil {
+		result = audit.Failure
+	}
+	r.writeAudit(operation, domain, oldTarget, newTarget, reasons[operation][domain], result, err)
+}
+
+// Writes a change to the audit log, if enabled.
+func (r *Reconciler) writeAudit(operation Operation, domain, oldTarget, newTarget string, reason audit.Reason, result audit.Result, err error) {
+	if r.auditLog == nil {
+		return
+	}
+	entry := audit.Entry{
+		Time:      time.Now(),
+		Backend:   r.name,
+		Operation: operation,
+		Domain:    domain,
+		OldTarget: oldTarget,
+		NewTarget: newTarget,
+		Reason:    reason,
+		Result:    result,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if err := r.auditLog.Write(entry); err != nil {
+		r.logger.Error("error writing audit log", "err", err)
+	}
+}
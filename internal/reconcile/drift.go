@@ -0,0 +1,120 @@
+package reconcile
+
+import (
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/nameserver"
+	"golang.org/x/exp/slices"
+)
+
+type DriftCategory = string
+
+const (
+	// The name holds a record of another type than CNAME, it must be recreated.
+	TypeDrift DriftCategory = "type"
+	// The name holds several values (duplicate rows or multi-value record set).
+	ValuesDrift DriftCategory = "values"
+	// The record points at a proxy host that doesn't serve the domain.
+	TargetDrift DriftCategory = "target"
+	// The record's TTL isn't the desired one.
+	TTLDrift DriftCategory = "ttl"
+)
+
+var driftCategories = []DriftCategory{TypeDrift, ValuesDrift, TargetDrift, TTLDrift}
+
+// Merges the records of each name into a single record. Names holding records
+// of several types are reported with the first non-CNAME type.
+func mergeRecords(records []nameserver.Record) map[string]nameserver.Record {
+	merged := map[string]nameserver.Record{}
+	for _, record := range records {
+		existing, ok := merged[record.Name]
+		if !ok {
+			record.Values = slices.Clone(record.Values)
+			merged[record.Name] = record
+			continue
+		}
+		existing.Values = append(existing.Values, record.Values...)
+		if existing.Type == nameserver.CNAME {
+			existing.Type = record.Type
+		}
+		merged[record.Name] = existing
+	}
+	return merged
+}
+
+// Compares an actual record against the desired one, returns the drift
+// category, or an empty string if the record is as desired.
+func (r *Reconciler) detectDrift(record nameserver.Record, desiredTTL int64) DriftCategory {
+	switch {
+	case record.Type != nameserver.CNAME:
+		return TypeDrift
+	case len(record.Values) != 1:
+		return ValuesDrift
+	case !r.isValidTarget(record.Name, record.Values[0]):
+		return TargetDrift
+	case record.TTL != desiredTTL:
+		return TTLDrift
+	}
+	return ""
+}
+
+// Returns whether any record of a domain that should exist drifted.
+func (r *Reconciler) hasDrift() bool {
+	if r.nameserverDomains == nil || r.proxyDomains == nil {
+		return false
+	}
+	for _, domain := range r.servedDomains().ToSlice() {
+		if r.detectDrift(r.nameserverRecords[domain], r.desiredTTL(domain)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the served domains holding records, including names holding no CNAME
+// record, whose records conflict with the one bingo would create.
+// Must be called with the lock held.
+func (r *Reconciler) servedDomains() mapset.Set[string] {
+	served := mapset.NewSet[string]()
+	for domain := range r.nameserverRecords {
+		if r.proxyDomains.Contains(domain) {
+			served.Add(domain)
+		}
+	}
+	return served
+}
+
+// Returns whether bingo created the domain's record, according to the state file.
+// Must be called with the lock held.
+func (r *Reconciler) owned(domain string) bool {
+	if r.state == nil {
+		return false
+	}
+	d, ok := r.state.Get(r.name, domain)
+	return ok && d.Owned()
+}
+
+// Returns whether the target is a valid one for the domain: the one it's pinned
+// to if any, a proxy host serving it otherwise.
+func (r *Reconciler) isValidTarget(domain, target string) bool {
+	if pinned, ok := r.overrides.PinnedTarget(domain); ok {
+		return target == pinned
+	}
+	return r.proxyBackend.IsValidTarget(domain, target)
+}
+
+// Returns the target to point the domain's record at.
+func (r *Reconciler) pickTarget(domain string, policy config.TargetPolicy) string {
+	if pinned, ok := r.overrides.PinnedTarget(domain); ok {
+		return pinned
+	}
+	return r.proxyBackend.GetTarget(domain, policy)
+}
+
+// Returns the TTL records for the domain should have.
+func (r *Reconciler) desiredTTL(domain string) int64 {
+	if rule := r.config().DomainRule(domain); rule != nil && rule.TTL > 0 {
+		return rule.TTL
+	}
+	return r.nameserver().DefaultTTL()
+}
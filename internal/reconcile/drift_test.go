@@ -0,0 +1,147 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/nameserver"
+)
+
+func TestMergeRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []nameserver.Record
+		want    map[string]nameserver.Record
+	}{
+		{
+			"single record",
+			[]nameserver.Record{{Name: "app.svc.local", Type: nameserver.CNAME, TTL: 300, Values: []string{"a.lan"}}},
+			map[string]nameserver.Record{"app.svc.local": {Name: "app.svc.local", Type: nameserver.CNAME, TTL: 300, Values: []string{"a.lan"}}},
+		},
+		{
+			"duplicate rows",
+			[]nameserver.Record{
+				{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"a.lan"}},
+				{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"b.lan"}},
+			},
+			map[string]nameserver.Record{"app.svc.local": {Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"a.lan", "b.lan"}}},
+		},
+		{
+			"CNAME then A",
+			[]nameserver.Record{
+				{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"a.lan"}},
+				{Name: "app.svc.local", Type: nameserver.A, Values: []string{"10.0.0.1"}},
+			},
+			map[string]nameserver.Record{"app.svc.local": {Name: "app.svc.local", Type: nameserver.A, Values: []string{"a.lan", "10.0.0.1"}}},
+		},
+		{
+			"A then CNAME",
+			[]nameserver.Record{
+				{Name: "app.svc.local", Type: nameserver.AAAA, Values: []string{"::1"}},
+				{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"a.lan"}},
+			},
+			map[string]nameserver.Record{"app.svc.local": {Name: "app.svc.local", Type: nameserver.AAAA, Values: []string{"::1", "a.lan"}}},
+		},
+		{
+			"several names",
+			[]nameserver.Record{
+				{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"a.lan"}},
+				{Name: "api.svc.local", Type: nameserver.A, Values: []string{"10.0.0.1", "10.0.0.2"}},
+			},
+			map[string]nameserver.Record{
+				"app.svc.local": {Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"a.lan"}},
+				"api.svc.local": {Name: "api.svc.local", Type: nameserver.A, Values: []string{"10.0.0.1", "10.0.0.2"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := mergeRecords(tt.records); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mergeRecords() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergeRecordsDoesntAlias(t *testing.T) {
+	records := []nameserver.Record{
+		{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"a.lan"}},
+		{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{"b.lan"}},
+	}
+	mergeRecords(records)
+	if len(records[0].Values) != 1 || records[0].Values[0] != "a.lan" {
+		t.Errorf("mergeRecords() modified its input: %v", records[0].Values)
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	r := newTestReconciler(t, newFakeNameserver(), testConfig(t))
+	tests := []struct {
+		record nameserver.Record
+		want   DriftCategory
+	}{
+		{nameserver.Record{Name: "app.svc.local", Type: nameserver.CNAME, TTL: 300, Values: []string{testTarget}}, ""},
+		{nameserver.Record{Name: "app.svc.local", Type: nameserver.A, TTL: 300, Values: []string{"10.0.0.1"}}, TypeDrift},
+		{nameserver.Record{Name: "app.svc.local", Type: nameserver.AAAA, TTL: 300, Values: []string{testTarget}}, TypeDrift},
+		{nameserver.Record{Name: "app.svc.local", Type: nameserver.CNAME, TTL: 300, Values: []string{testTarget, testTarget}}, ValuesDrift},
+		{nameserver.Record{Name: "app.svc.local", Type: nameserver.CNAME, TTL: 300, Values: []string{testTarget, "other.lan"}}, ValuesDrift},
+		{nameserver.Record{Name: "app.svc.local", Type: nameserver.CNAME, TTL: 300, Values: []string{}}, ValuesDrift},
+		{nameserver.Record{Name: "app.svc.local", Type: nameserver.CNAME, TTL: 300, Values: []string{"other.lan"}}, TargetDrift},
+		{nameserver.Record{Name: "app.svc.local", Type: nameserver.CNAME, TTL: 60, Values: []string{testTarget}}, TTLDrift},
+	}
+	for _, tt := range tests {
+		if got := r.detectDrift(tt.record, 300); got != tt.want {
+			t.Errorf("detectDrift(%v) = %q, want %q", tt.record, got, tt.want)
+		}
+	}
+}
+
+func TestDetectDriftPinned(t *testing.T) {
+	r := newTestReconciler(t, newFakeNameserver(), testConfig(t))
+	if err := r.overrides.Pin("app.svc.local", "pinned.lan"); err != nil {
+		t.Fatal(err)
+	}
+	record := nameserver.Record{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{testTarget}}
+	if got := r.detectDrift(record, 0); got != TargetDrift {
+		t.Errorf("record not pointing at its pinned target: drift = %q, want %q", got, TargetDrift)
+	}
+	record.Values = []string{"pinned.lan"}
+	if got := r.detectDrift(record, 0); got != "" {
+		t.Errorf("record pointing at its pinned target: drift = %q, want none", got)
+	}
+}
+
+func TestDiffConflictingRecords(t *testing.T) {
+	tests := []struct {
+		replace    bool
+		wantDelete []string
+		wantCreate []string
+	}{
+		{false, []string{}, []string{"app.svc.local"}},
+		{true, []string{"manual.svc.local"}, []string{"app.svc.local", "manual.svc.local"}},
+	}
+	for _, tt := range tests {
+		conf := testConfig(t)
+		conf.Nameserver.ReplaceConflicting = tt.replace
+		r := newTestReconciler(t, newFakeNameserver(), conf)
+		r.SetNameserverRecords([]nameserver.Record{
+			{Name: "manual.svc.local", Type: nameserver.A, Values: []string{"10.0.0.1"}},
+			{Name: "static.svc.local", Type: nameserver.A, Values: []string{"10.0.0.2"}},
+		})
+		r.SetProxyDomains(mapset.NewSet("app.svc.local", "manual.svc.local"))
+
+		toCreate, toDelete, toUpdate := r.Diff()
+		if got := sorted(toDelete); !reflect.DeepEqual(got, tt.wantDelete) {
+			t.Errorf("replace = %t: deleted %v, want %v", tt.replace, got, tt.wantDelete)
+		}
+		if got := sorted(toCreate); !reflect.DeepEqual(got, tt.wantCreate) {
+			t.Errorf("replace = %t: created %v, want %v", tt.replace, got, tt.wantCreate)
+		}
+		if !isEmpty(toUpdate) {
+			t.Errorf("replace = %t: updated %v, want none", tt.replace, sorted(toUpdate))
+		}
+		// Conflicts are reported either way
+		if r.drift["manual.svc.local"] != TypeDrift {
+			t.Errorf("replace = %t: drift = %v, want a type drift", tt.replace, r.drift)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package reconcile
+
+import (
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+)
+
+// A domain that vanished from the proxies, whose record is waiting for the
+// grace period to be over before being deleted.
+type pendingDeletion struct {
+	// When the domain was last served by a proxy, or first noticed missing.
+	since time.Time
+	// Number of consecutive proxy polls the domain was missing from.
+	polls int
+}
+
+func (r *Reconciler) graceEnabled() bool {
+	return r.config().Deletion.GracePeriod > 0 || r.config().Deletion.GracePolls > 0
+}
+
+func (r *Reconciler) graceExpired(p *pendingDeletion, now time.Time) bool {
+	if !r.graceEnabled() {
+		return true
+	}
+	grace := r.config().Deletion
+	return (grace.GracePeriod > 0 && now.Sub(p.since) >= grace.GracePeriod) ||
+		(grace.GracePolls > 0 && p.polls >= grace.GracePolls)
+}
+
+// Tracks the domains that have records but vanished from the proxies. poll
+// tells whether the proxy domains were just polled.
+// Must be called with the lock held.
+func (r *Reconciler) updatePendingDeletions(now time.Time, poll bool) {
+	if r.nameserverDomains == nil || r.proxyDomains == nil {
+		return
+	}
+	vanished := r.nameserverDomains.Difference(r.proxyDomains)
+
+	for domain := range r.pendingDeletions {
+		if vanished.Contains(domain) {
+			continue
+		}
+		delete(r.pendingDeletions, domain)
+		if r.graceEnabled() && r.proxyDomains.Contains(domain) {
+			r.logger.Info("domain is served again, cancelling its deletion", "domain", domain)
+		}
+	}
+
+	for _, domain := range vanished.ToSlice() {
+		p, ok := r.pendingDeletions[domain]
+		if !ok {
+			p = &pendingDeletion{since: now}
+			// Count the grace period from when the domain was last served, even
+			// before a restart.
+			if r.state != nil {
+				if d, ok := r.state.Get(r.name, domain); ok && !d.LastSeen.IsZero() {
+					p.since = d.LastSeen
+				}
+			}
+			r.pendingDeletions[domain] = p
+			if r.graceEnabled() {
+				r.logger.Info(
+					"domain vanished from the proxies, deleting it after the grace period",
+					"domain", domain,
+					"grace_period", r.config().Deletion.GracePeriod,
+					"grace_polls", r.config().Deletion.GracePolls,
+				)
+			}
+		}
+		if poll {
+			p.polls++
+		}
+	}
+}
+
+// Returns the vanished domains whose grace period is over, and sets the number
+// of pending deletions.
+// Must be called with the lock held.
+func (r *Reconciler) expiredDeletions(now time.Time) mapset.Set[string] {
+	expired := mapset.NewSet[string]()
+	pending := 0
+	for domain, p := range r.pendingDeletions {
+		if r.graceExpired(p, now) {
+			expired.Add(domain)
+		} else {
+			pending++
+		}
+	}
+	pendingDeletionsGauge.WithLabelValues(r.name).Set(float64(pending))
+	return expired
+}
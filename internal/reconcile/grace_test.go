@@ -0,0 +1,87 @@
+package reconcile
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/nameserver"
+)
+
+// A proxy poll, and the deletions the following diff should make.
+type graceStep struct {
+	served  []string
+	elapsed time.Duration // since the previous poll
+	deleted []string
+}
+
+func TestGracePeriod(t *testing.T) {
+	tests := []struct {
+		name   string
+		period time.Duration
+		polls  int
+		steps  []graceStep
+	}{
+		{"no grace", 0, 0, []graceStep{
+			{served: []string{"app.svc.local"}, deleted: []string{"api.svc.local"}},
+		}},
+		{"grace polls", 0, 3, []graceStep{
+			{served: []string{"app.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local"}, deleted: []string{"api.svc.local"}},
+		}},
+		{"grace period", 10 * time.Minute, 0, []graceStep{
+			{served: []string{"app.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local"}, elapsed: 5 * time.Minute, deleted: []string{}},
+			{served: []string{"app.svc.local"}, elapsed: 6 * time.Minute, deleted: []string{"api.svc.local"}},
+		}},
+		{"polls before period", time.Hour, 2, []graceStep{
+			{served: []string{"app.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local"}, deleted: []string{"api.svc.local"}},
+		}},
+		{"period before polls", 10 * time.Minute, 10, []graceStep{
+			{served: []string{"app.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local"}, elapsed: 11 * time.Minute, deleted: []string{"api.svc.local"}},
+		}},
+		{"reappearing cancels the polls", 0, 2, []graceStep{
+			{served: []string{"app.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local", "api.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local"}, deleted: []string{"api.svc.local"}},
+		}},
+		{"reappearing cancels the period", 10 * time.Minute, 0, []graceStep{
+			{served: []string{"app.svc.local"}, deleted: []string{}},
+			{served: []string{"app.svc.local", "api.svc.local"}, elapsed: 9 * time.Minute, deleted: []string{}},
+			{served: []string{"app.svc.local"}, elapsed: 9 * time.Minute, deleted: []string{}},
+			{served: []string{"app.svc.local"}, elapsed: 9 * time.Minute, deleted: []string{}},
+			{served: []string{"app.svc.local"}, elapsed: 2 * time.Minute, deleted: []string{"api.svc.local"}},
+		}},
+		{"every domain vanishing", 0, 2, []graceStep{
+			{served: []string{}, deleted: []string{}},
+			{served: []string{}, deleted: []string{"api.svc.local", "app.svc.local"}},
+		}},
+	}
+	for _, tt := range tests {
+		conf := testConfig(t)
+		conf.Deletion.GracePeriod = tt.period
+		conf.Deletion.GracePolls = tt.polls
+		r := newTestReconciler(t, newFakeNameserver(), conf)
+		r.SetNameserverRecords([]nameserver.Record{
+			{Name: "app.svc.local", Type: nameserver.CNAME, Values: []string{testTarget}},
+			{Name: "api.svc.local", Type: nameserver.CNAME, Values: []string{testTarget}},
+		})
+
+		for i, step := range tt.steps {
+			// Move pending deletions back in time rather than waiting
+			for _, p := range r.pendingDeletions {
+				p.since = p.since.Add(-step.elapsed)
+			}
+			r.SetProxyDomains(mapset.NewSet(step.served...))
+			_, toDelete, _ := r.Diff()
+			if got := sorted(toDelete); !reflect.DeepEqual(got, step.deleted) {
+				t.Errorf("%s, poll %d: deleted %v, want %v", tt.name, i+1, got, step.deleted)
+			}
+		}
+	}
+}
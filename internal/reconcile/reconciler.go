@@ -1,185 +1,790 @@
 package reconcile
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/audit"
 	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/health"
+	"github.com/n6g7/bingo/internal/leader"
 	"github.com/n6g7/bingo/internal/nameserver"
+	"github.com/n6g7/bingo/internal/notify"
+	"github.com/n6g7/bingo/internal/overrides"
 	"github.com/n6g7/bingo/internal/proxy"
+	"github.com/n6g7/bingo/internal/state"
+	"github.com/n6g7/bingo/internal/tracing"
 	"github.com/n6g7/nomtail/pkg/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/n6g7/bingo/internal/reconcile")
+
 var (
-	deletionCounter = promauto.NewCounter(prometheus.CounterOpts{
+	deletionCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "bingo_deleted_records",
 		Help: "The total number of deleted records",
-	})
-	creationCounter = promauto.NewCounter(prometheus.CounterOpts{
+	}, []string{"backend"})
+	creationCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "bingo_created_records",
 		Help: "The total number of created records",
-	})
-	managedGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	}, []string{"backend"})
+	updateCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bingo_updated_records",
+		Help: "The total number of records updated in place",
+	}, []string{"backend"})
+	pendingDeletionsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_pending_deletions",
+		Help: "The number of records whose domain vanished from the proxies, waiting for the deletion grace period",
+	}, []string{"backend"})
+	refusedDeletionsGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_refused_deletions",
+		Help: "The number of record deletions refused because too many records would have been deleted at once",
+	}, []string{"backend"})
+	driftGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_drifted_records",
+		Help: "The number of records differing from their desired state",
+	}, []string{"backend", "category"})
+	managedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "bingo_managed_records",
 		Help: "The number of managed records",
-	})
+	}, []string{"backend"})
+	errorCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bingo_reconciliation_errors",
+		Help: "The total number of failed reconciliations",
+	}, []string{"backend"})
+	changeFailureCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bingo_record_change_failures",
+		Help: "The total number of failed record changes",
+	}, []string{"backend", "domain", "operation"})
+	quarantineGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_quarantined_changes",
+		Help: "The number of record changes quarantined after too many failures",
+	}, []string{"backend"})
+	pausedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_paused",
+		Help: "Whether record changes are paused (1) or not (0)",
+	}, []string{"backend"})
+	inSyncGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_in_sync",
+		Help: "Whether the records are in sync with the proxy domains (1) or not (0)",
+	}, []string{"backend"})
+	lastSuccessGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_reconciliation_last_success_timestamp_seconds",
+		Help: "When the records were last found or brought in sync with the proxy domains, as a Unix timestamp",
+	}, []string{"backend"})
+	durationGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bingo_reconciliation_duration_seconds",
+		Help: "Duration of the last reconciliation",
+	}, []string{"backend"})
 )
 
+// Reconciles the records of a single nameserver backend with the proxy domains.
 type Reconciler struct {
+	mu                 sync.Mutex
 	logger             *log.Logger
+	name               string
 	nameserverDomains  mapset.Set[string]
+	nameserverRecords  map[string]nameserver.Record
 	proxyDomains       mapset.Set[string]
 	needsDiff          bool
 	proxyBackend       proxy.Proxy
+	nsMu               sync.RWMutex // guards nsBackend, replaced when the config is reloaded
 	nsBackend          nameserver.Nameserver
 	lastReconciliation time.Time
-	minimumWait        time.Duration
-	loopTimeout        time.Duration
 	deletionQueue      mapset.Set[string]
-	conf               *config.Config
+	pendingDeletions   map[string]*pendingDeletion
+	retargetQueue      mapset.Set[string]
+	refusedDeletions   mapset.Set[string]
+	drift              map[string]DriftCategory
+	lastDiff           *DiffStatus
+	lastChanges        map[string]Change
+	history            []HistoryEntry
+	lastError          error
+	health             *health.Check
+	failures           *failureTracker
+	state              *state.Store
+	elector            *leader.Elector
+	overrides          *overrides.Overrides
+	notifier           *notify.Notifier
+	auditLog           *audit.Log
+	paused             bool
+	forced             bool // reconcile without waiting for the reconciliation timeout
+	conf               atomic.Pointer[config.Config]
 }
 
 func NewReconciler(
 	logger *log.Logger,
+	name string,
 	ns nameserver.Nameserver,
 	prox proxy.Proxy,
+	store *state.Store,
+	elector *leader.Elector,
+	overrides *overrides.Overrides,
+	notifier *notify.Notifier,
+	auditLog *audit.Log,
 	conf *config.Config,
 ) *Reconciler {
-	return &Reconciler{
-		logger:             logger.With("component", "reconciler"),
+	r := &Reconciler{
+		logger:             logger.With("component", "reconciler", "backend", name),
+		name:               name,
 		nameserverDomains:  nil,
 		proxyDomains:       nil,
 		needsDiff:          false,
 		proxyBackend:       prox,
 		nsBackend:          ns,
 		lastReconciliation: time.Unix(0, 0),
-		minimumWait:        conf.ReconciliationTimeout,
-		loopTimeout:        conf.ReconcilerLoopTimeout,
 		deletionQueue:      mapset.NewSet[string](),
-		conf:               conf,
+		pendingDeletions:   map[string]*pendingDeletion{},
+		retargetQueue:      mapset.NewSet[string](),
+		refusedDeletions:   mapset.NewSet[string](),
+		drift:              map[string]DriftCategory{},
+		lastChanges:        map[string]Change{},
+		failures:           newFailureTracker(conf.Retry),
+		health:             health.NewCheck(),
+		state:              store,
+		elector:            elector,
+		overrides:          overrides,
+		notifier:           notifier,
+		auditLog:           auditLog,
+	}
+	r.conf.Store(conf)
+
+	// Warm start: assume the domains last served by the proxy are still
+	// served, until the proxy is polled.
+	if store != nil {
+		domains := mapset.NewSet[string]()
+		for domain, d := range store.Domains(name) {
+			if !d.LastSeen.IsZero() {
+				domains.Add(domain)
+			}
+		}
+		if domains.Cardinality() > 0 {
+			r.logger.Info("restored proxy domains from state", "count", domains.Cardinality())
+			r.proxyDomains = domains
+		}
 	}
+
+	return r
+}
+
+// Applies a reloaded config, the reconciler's state is kept. Domains that
+// aren't managed anymore are left alone.
+func (r *Reconciler) SetConfig(conf *config.Config) {
+	r.conf.Store(conf)
+	r.failures.setConf(conf.Retry)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.needsDiff = true
 }
 
-func (r *Reconciler) SetNameserverDomains(nsDomains mapset.Set[string]) {
-	r.logger.Trace("received NS domains", "domains", nsDomains.ToSlice())
-	if reflect.DeepEqual(nsDomains, r.nameserverDomains) {
+func (r *Reconciler) config() *config.Config {
+	return r.conf.Load()
+}
+
+// Replaces the nameserver, eg. when its config is reloaded. It must be
+// initialized already.
+func (r *Reconciler) SetNameserver(ns nameserver.Nameserver) {
+	r.nsMu.Lock()
+	r.nsBackend = ns
+	r.nsMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.needsDiff = true
+}
+
+func (r *Reconciler) nameserver() nameserver.Nameserver {
+	r.nsMu.RLock()
+	defer r.nsMu.RUnlock()
+	return r.nsBackend
+}
+
+func (r *Reconciler) Name() string {
+	return r.name
+}
+
+// Tracks reconciliations, which succeed when records are in sync with the
+// proxy domains.
+func (r *Reconciler) Health() *health.Check {
+	return r.health
+}
+
+// Returns the error of the last reconciliation attempt, if it failed.
+func (r *Reconciler) LastError() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastError
+}
+
+func (r *Reconciler) SetNameserverRecords(records []nameserver.Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger.Trace("received NS records", "records", records)
+	nsRecords := mergeRecords(records)
+	if reflect.DeepEqual(nsRecords, r.nameserverRecords) {
+		// Records can drift without changing, eg. when a proxy host stops
+		// serving a domain.
+		if r.hasDrift() {
+			r.needsDiff = true
+		}
 		return
 	}
-	r.nameserverDomains = nsDomains
+	r.nameserverRecords = nsRecords
+	// Names holding no CNAME record aren't managed, their records only matter
+	// when they conflict with a served domain.
+	r.nameserverDomains = mapset.NewSet[string]()
+	for _, record := range records {
+		if record.Type == nameserver.CNAME {
+			r.nameserverDomains.Add(record.Name)
+		}
+	}
 	r.needsDiff = true
 }
 
 func (r *Reconciler) SetProxyDomains(proxyDomains mapset.Set[string]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.logger.Trace("received proxy domains", "domains", proxyDomains.ToSlice())
-	if reflect.DeepEqual(proxyDomains, r.proxyDomains) {
-		return
+	now := time.Now()
+	if r.state != nil {
+		r.state.Seen(r.name, proxyDomains.ToSlice(), now)
+	}
+	if !reflect.DeepEqual(proxyDomains, r.proxyDomains) {
+		r.proxyDomains = proxyDomains
+		r.needsDiff = true
+	}
+	r.updatePendingDeletions(now, true)
+	// Grace periods may be over
+	if len(r.pendingDeletions) > 0 {
+		r.needsDiff = true
+	}
+}
+
+// Diffs again as soon as possible, even if nothing changed, and applies the
+// changes without waiting for the reconciliation timeout. Quarantined changes
+// are attempted again.
+func (r *Reconciler) ForceSync() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if released := r.failures.release(); released > 0 {
+		r.logger.Info("releasing quarantined changes", "changes", released)
+		quarantineGauge.WithLabelValues(r.name).Set(float64(r.failures.quarantinedCount()))
+	}
+	r.needsDiff = true
+	r.forced = true
+}
+
+// Stops changing records, diffs keep running.
+func (r *Reconciler) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.paused {
+		r.logger.Warn("pausing record changes")
+	}
+	r.paused = true
+	pausedGauge.WithLabelValues(r.name).Set(1)
+}
+
+func (r *Reconciler) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.paused {
+		r.logger.Info("resuming record changes")
 	}
-	r.proxyDomains = proxyDomains
+	r.paused = false
 	r.needsDiff = true
+	pausedGauge.WithLabelValues(r.name).Set(0)
 }
 
+// Queues a domain's record for deletion, regardless of the deletion grace
+// period. It's recreated if the domain is still served.
 func (r *Reconciler) MarkForDeletion(domain string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.deletionQueue.Add(domain)
 	r.needsDiff = true
 }
 
-func (r *Reconciler) Diff() (toCreate mapset.Set[string], toDelete mapset.Set[string]) {
+// Forces a domain's record to be pointed at a new target, the record is updated
+// in place.
+func (r *Reconciler) MarkForRetarget(domain string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retargetQueue.Add(domain)
+	r.needsDiff = true
+}
+
+func (r *Reconciler) Diff() (toCreate, toDelete, toUpdate mapset.Set[string]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	if r.nameserverDomains == nil {
 		r.logger.Debug("reconciler not ready to diff, no nameserver domains yet")
-		return nil, nil
+		return nil, nil, nil
 	}
 	if r.proxyDomains == nil {
 		r.logger.Debug("reconciler not ready to diff, no proxy domains yet")
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	toDelete = r.nameserverDomains.Difference(r.proxyDomains).Union(r.deletionQueue)                           // NS - P + D
-	toCreate = r.proxyDomains.Difference(r.nameserverDomains).Union(r.deletionQueue.Intersect(r.proxyDomains)) // P - NS + (D&P)
+	// Domains that aren't in the proxy anymore are deleted rather than retargeted
+	r.retargetQueue = r.retargetQueue.Intersect(r.nameserverDomains).Intersect(r.proxyDomains)
+	// Records of unmanaged names are only deleted when they conflict with a served domain
+	served := r.servedDomains()
+	r.deletionQueue = r.deletionQueue.Intersect(r.nameserverDomains.Union(served))
 
-	managedGauge.Set(float64(r.proxyDomains.Cardinality()))
+	// Compare the records of domains that should exist with the desired records
+	recreate := mapset.NewSet[string]()
+	update := mapset.NewSet[string]()
+	conflicting := mapset.NewSet[string]() // left alone
+	drift := map[string]DriftCategory{}
+	driftCounts := map[DriftCategory]int{}
+	for domain := range served.Iter() {
+		category := r.detectDrift(r.nameserverRecords[domain], r.desiredTTL(domain))
+		if category == "" {
+			continue
+		}
+		if _, ok := r.drift[domain]; !ok {
+			r.logger.Info("record drifted from desired state", "domain", domain, "category", category)
+		}
+		drift[domain] = category
+		driftCounts[category]++
+		if category == TypeDrift {
+			// Records of other types may have been made by hand, only replace
+			// them when asked to or when bingo owns the name
+			if r.config().Nameserver.ReplaceConflicting || r.owned(domain) {
+				recreate.Add(domain)
+				continue
+			}
+			if _, ok := r.drift[domain]; !ok {
+				r.logger.Warn("leaving conflicting records alone, the domain's record can't be created", "domain", domain)
+			}
+			conflicting.Add(domain)
+		} else {
+			update.Add(domain)
+		}
+	}
+	r.drift = drift
+	for _, category := range driftCategories {
+		driftGauge.WithLabelValues(r.name, category).Set(float64(driftCounts[category]))
+	}
+
+	// Domains that vanished from the proxies are only deleted after the grace period
+	now := time.Now()
+	r.updatePendingDeletions(now, false)
+	vanished := r.expiredDeletions(now)
+
+	queued := r.deletionQueue.Union(recreate)
+	toDelete = vanished.Union(queued)                                                                 // NS - P + D
+	toCreate = r.proxyDomains.Difference(r.nameserverDomains).Union(queued.Intersect(r.proxyDomains)) // P - NS + (D&P)
+	toUpdate = r.retargetQueue.Union(update).Difference(queued)                                       // R - D
+	toCreate = toCreate.Difference(conflicting)
+	toUpdate = toUpdate.Difference(conflicting)
+
+	// Leave records of domains excluded at runtime alone
+	excluded := mapset.NewSet[string]()
+	for _, domain := range r.nameserverDomains.Union(r.proxyDomains).ToSlice() {
+		if r.overrides.Excluded(domain) {
+			excluded.Add(domain)
+		}
+	}
+	toDelete = toDelete.Difference(excluded)
+	toCreate = toCreate.Difference(excluded)
+	toUpdate = toUpdate.Difference(excluded)
+
+	// Only touch existing records bingo created
+	if r.config().State.OwnedOnly && r.state != nil {
+		notOwned := mapset.NewSet[string]()
+		for domain := range r.nameserverRecords {
+			if d, ok := r.state.Get(r.name, domain); !ok || !d.Owned() {
+				notOwned.Add(domain)
+			}
+		}
+		toDelete = toDelete.Difference(notOwned)
+		toCreate = toCreate.Difference(notOwned.Intersect(queued))
+		toUpdate = toUpdate.Difference(notOwned)
+	}
+
+	managedGauge.WithLabelValues(r.name).Set(float64(r.proxyDomains.Cardinality()))
+
+	r.lastDiff = &DiffStatus{
+		Time:   now,
+		Create: sorted(toCreate),
+		Delete: sorted(toDelete),
+		Update: sorted(toUpdate),
+	}
+	r.pruneChanges(now)
 
 	return
 }
 
-func (r *Reconciler) Reconcile(toCreate, toDelete mapset.Set[string]) error {
-	r.lastReconciliation = time.Now()
+// Applies the changes, each one independently of the others: a failing change
+// doesn't prevent the other ones from being applied. Failed changes are retried
+// with exponential backoff, and quarantined after too many failures.
+func (r *Reconciler) Reconcile(ctx context.Context, toCreate, toDelete, toUpdate mapset.Set[string]) error {
+	now := time.Now()
+	r.mu.Lock()
+	r.lastReconciliation = now
+	reasons := r.changeReasons(toCreate, toDelete, toUpdate)
+	r.mu.Unlock()
+	events := []notify.Event{}
+	defer func() {
+		if r.notifier != nil {
+			r.notifier.Notify(notify.Batch{Backend: r.name, Time: now, Events: events})
+		}
+	}()
+
+	errs := []error{}
+
+	// Refuse to delete many records at once, it's more likely caused by a
+	// misbehaving proxy than by that many services going away.
+	refused := mapset.NewSet[string]()
+	if max := r.config().Deletion.MaxRecords; max > 0 && toDelete.Cardinality() > max {
+		refused = toDelete
+		toCreate = toCreate.Difference(toDelete) // their records still exist
+		toDelete = mapset.NewSet[string]()
+		errs = append(errs, fmt.Errorf("refused to delete %d records, more than %d", refused.Cardinality(), max))
+	}
+	refusedDeletionsGauge.WithLabelValues(r.name).Set(float64(refused.Cardinality()))
+	r.mu.Lock()
+	// Only log, audit and notify the same refused deletions once
+	if refused.Cardinality() > 0 && !refused.Equal(r.refusedDeletions) {
+		r.logger.Error("refusing to delete too many records at once", "count", refused.Cardinality(), "max", r.config().Deletion.MaxRecords, "domains", sorted(refused))
+		for _, domain := range sorted(refused) {
+			previous := r.currentTarget(domain)
+			r.writeAudit(DeleteOperation, domain, previous, "", reasons[DeleteOperation][domain], audit.Refused, nil)
+			events = append(events, notify.Event{
+				Type:           notify.RefusedDeletionEvent,
+				Domain:         domain,
+				PreviousTarget: previous,
+			})
+		}
+	}
+	r.refusedDeletions = refused
+	r.mu.Unlock()
+
+	r.failures.prune(map[Operation]mapset.Set[string]{
+		CreateOperation: toCreate,
+		DeleteOperation: toDelete,
+		UpdateOperation: toUpdate,
+	})
+
+	// Retargeted records are updated in place, so that clients never get an
+	// NXDOMAIN for them.
+	for domain := range toUpdate.Iter() {
+		if !r.shouldAttempt(UpdateOperation, domain, now) {
+			continue
+		}
+
+		r.mu.Lock()
+		previous := r.currentTarget(domain)
+		r.mu.Unlock()
+		target, err := r.updateRecord(ctx, domain)
+		r.auditChange(UpdateOperation, domain, previous, target, reasons, err)
+		if err != nil {
+			errs = append(errs, r.recordFailure(UpdateOperation, domain, err, now))
+			continue
+		}
+		events = append(events, notify.Event{Type: notify.UpdateEvent, Domain: domain, Target: target, PreviousTarget: previous})
+		r.failures.succeed(UpdateOperation, domain)
+		r.mu.Lock()
+		r.retargetQueue.Remove(domain)
+		r.mu.Unlock()
+	}
 
 	// Start by deleting, gives us a chance to immediately recreate domains in
 	// the deletion queue that are in the proxy (they need a new target).
 	for domain := range toDelete.Iter() {
-		if !r.conf.IsServiceDomain(domain) {
-			return fmt.Errorf("won't delete \"%s\": not a service domain", domain)
+		if !r.shouldAttempt(DeleteOperation, domain, now) {
+			continue
 		}
 
-		r.logger.Info("deleting domain...", "domain", domain)
-		err := r.nsBackend.RemoveRecord(domain)
+		r.mu.Lock()
+		previous := r.currentTarget(domain)
+		r.mu.Unlock()
+		err := r.deleteRecord(ctx, domain)
+		r.auditChange(DeleteOperation, domain, previous, "", reasons, err)
 		if err != nil {
-			return fmt.Errorf("record deletion failed: %w", err)
-		} else {
-			deletionCounter.Inc()
-			r.logger.Debug("deleted domain", "domain", domain)
+			errs = append(errs, r.recordFailure(DeleteOperation, domain, err, now))
+			continue
 		}
+		events = append(events, notify.Event{Type: notify.DeleteEvent, Domain: domain, PreviousTarget: previous})
+		r.failures.succeed(DeleteOperation, domain)
+		r.mu.Lock()
+		r.deletionQueue.Remove(domain)
+		r.mu.Unlock()
 	}
 
 	for domain := range toCreate.Iter() {
-		if !r.conf.IsServiceDomain(domain) {
-			return fmt.Errorf("won't create \"%s\": not a service domain", domain)
+		if !r.shouldAttempt(CreateOperation, domain, now) {
+			continue
 		}
 
-		r.logger.Info("creating domain...", "domain", domain)
-		target := r.proxyBackend.GetTarget(domain)
-		err := r.nsBackend.AddRecord(domain, target)
+		target, err := r.createRecord(ctx, domain)
+		r.auditChange(CreateOperation, domain, "", target, reasons, err)
 		if err != nil {
-			return fmt.Errorf("record creation failed: %w", err)
-		} else {
-			creationCounter.Inc()
-			r.logger.Debug("created domain", "domain", domain)
+			errs = append(errs, r.recordFailure(CreateOperation, domain, err, now))
+			continue
 		}
+		events = append(events, notify.Event{Type: notify.CreateEvent, Domain: domain, Target: target})
+		r.failures.succeed(CreateOperation, domain)
 	}
 
+	quarantineGauge.WithLabelValues(r.name).Set(float64(r.failures.quarantinedCount()))
+
+	if r.state != nil {
+		if err := r.state.Save(); err != nil {
+			r.logger.Error("error saving state", "err", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d record change(s) failed: %w", len(errs), errors.Join(errs...))
+	}
 	return nil
 }
 
+func (r *Reconciler) shouldAttempt(operation Operation, domain string, now time.Time) bool {
+	ready, quarantined := r.failures.ready(operation, domain, now)
+	if quarantined {
+		r.logger.Trace("skipping quarantined change", "operation", operation, "domain", domain)
+	} else if !ready {
+		r.logger.Trace("skipping change until its next attempt", "operation", operation, "domain", domain)
+	}
+	return ready
+}
+
+func (r *Reconciler) recordFailure(operation Operation, domain string, err error, now time.Time) error {
+	changeFailureCounter.WithLabelValues(r.name, domain, operation).Inc()
+	f := r.failures.fail(operation, domain, err, now)
+	if r.failures.isQuarantined(f) {
+		r.logger.Error("record change failed too many times, quarantining it", "operation", operation, "domain", domain, "failures", f.count, "err", err)
+	} else {
+		r.logger.Warn("record change failed, will attempt again", "operation", operation, "domain", domain, "failures", f.count, "next_attempt_in", f.nextAttempt.Sub(now).Round(time.Second), "err", err)
+	}
+	return fmt.Errorf("%s \"%s\": %w", operation, domain, err)
+}
+
+func (r *Reconciler) deleteRecord(ctx context.Context, domain string) error {
+	if rule := r.config().DomainRule(domain); rule == nil || !rule.HasNameserver(r.name) {
+		return fmt.Errorf("won't delete \"%s\": not a service domain", domain)
+	}
+
+	r.logger.Info("deleting domain...", "domain", domain)
+	err := r.nameserver().RemoveRecord(ctx, domain)
+	if err != nil {
+		return fmt.Errorf("record deletion failed: %w", err)
+	}
+	deletionCounter.WithLabelValues(r.name).Inc()
+	r.logger.Debug("deleted domain", "domain", domain)
+	r.recordChange(domain, DeleteOperation, "")
+	if r.state != nil {
+		r.state.Forget(r.name, domain)
+	}
+
+	// Avoid deleting the record again before the nameserver is polled
+	r.mu.Lock()
+	delete(r.nameserverRecords, domain)
+	r.nameserverDomains.Remove(domain)
+	r.mu.Unlock()
+	return nil
+}
+
+// Returns the target of the created record.
+func (r *Reconciler) createRecord(ctx context.Context, domain string) (string, error) {
+	rule := r.config().DomainRule(domain)
+	if rule == nil || !rule.HasNameserver(r.name) {
+		return "", fmt.Errorf("won't create \"%s\": not a service domain", domain)
+	}
+
+	r.logger.Info("creating domain...", "domain", domain)
+	target := r.pickTarget(domain, rule.TargetPolicy)
+	err := r.nameserver().AddRecord(ctx, domain, target, rule.TTL)
+	if err != nil {
+		return "", fmt.Errorf("record creation failed: %w", err)
+	}
+	creationCounter.WithLabelValues(r.name).Inc()
+	r.logger.Debug("created domain", "domain", domain)
+	r.recordChange(domain, CreateOperation, target)
+	if r.state != nil {
+		r.state.Written(r.name, domain, target, time.Now())
+	}
+
+	// Avoid creating the record again before the nameserver is polled
+	r.mu.Lock()
+	r.nameserverRecords[domain] = nameserver.Record{
+		Name:   domain,
+		Type:   nameserver.CNAME,
+		TTL:    r.desiredTTL(domain),
+		Values: []string{target},
+	}
+	r.nameserverDomains.Add(domain)
+	r.mu.Unlock()
+	return target, nil
+}
+
+// Returns the target of the updated record.
+func (r *Reconciler) updateRecord(ctx context.Context, domain string) (string, error) {
+	rule := r.config().DomainRule(domain)
+	if rule == nil || !rule.HasNameserver(r.name) {
+		return "", fmt.Errorf("won't update \"%s\": not a service domain", domain)
+	}
+
+	// Keep the current target if it's still valid, unless a retarget was requested
+	r.mu.Lock()
+	record, exists := r.nameserverRecords[domain]
+	forceRetarget := r.retargetQueue.Contains(domain)
+	r.mu.Unlock()
+	target := ""
+	if exists && !forceRetarget && len(record.Values) > 0 && r.isValidTarget(domain, record.Values[0]) {
+		target = record.Values[0]
+	} else {
+		target = r.pickTarget(domain, rule.TargetPolicy)
+	}
+
+	r.logger.Info("updating domain...", "domain", domain, "target", target)
+	err := r.nameserver().UpdateRecord(ctx, domain, target, rule.TTL)
+	if err != nil {
+		return "", fmt.Errorf("record update failed: %w", err)
+	}
+	updateCounter.WithLabelValues(r.name).Inc()
+	r.logger.Debug("updated domain", "domain", domain)
+	r.recordChange(domain, UpdateOperation, target)
+	if r.state != nil {
+		r.state.Written(r.name, domain, target, time.Now())
+	}
+
+	// Avoid updating the record again before the nameserver is polled
+	r.mu.Lock()
+	r.nameserverRecords[domain] = nameserver.Record{
+		Name:   domain,
+		Type:   nameserver.CNAME,
+		TTL:    r.desiredTTL(domain),
+		Values: []string{target},
+	}
+	r.mu.Unlock()
+	return target, nil
+}
+
+// Returns the target of the domain's record, empty if it has none.
+// Must be called with the lock held.
+func (r *Reconciler) currentTarget(domain string) string {
+	record, ok := r.nameserverRecords[domain]
+	if !ok || len(record.Values) == 0 {
+		return ""
+	}
+	return record.Values[0]
+}
+
+func (r *Reconciler) diffNeeded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.needsDiff
+}
+
 func (r *Reconciler) Run() error {
 	tooEarlyWarningSent := false
+	standbyNoticeSent := false
 	previouslyInSync := false
 
 	for {
-		if r.needsDiff {
-			toCreate, toDelete := r.Diff()
+		r.health.Beat()
+		if r.diffNeeded() {
+			toCreate, toDelete, toUpdate := r.Diff()
 
-			if (toCreate == nil || toCreate.Cardinality() == 0) && (toDelete == nil || toDelete.Cardinality() == 0) {
+			if toCreate == nil {
+				// Not ready to diff, records or proxy domains will come with
+				// another diff request
+				r.mu.Lock()
+				r.needsDiff = false
+				r.mu.Unlock()
+			} else if isEmpty(toCreate) && isEmpty(toDelete) && isEmpty(toUpdate) {
 				if !previouslyInSync {
 					r.logger.Info("proxy and nameserver are in sync")
 					previouslyInSync = true
 				}
+				inSyncGauge.WithLabelValues(r.name).Set(1)
+				lastSuccessGauge.WithLabelValues(r.name).SetToCurrentTime()
+				r.mu.Lock()
 				r.needsDiff = false
+				r.forced = false
+				r.mu.Unlock()
+				r.health.Succeed()
 			} else {
 				if previouslyInSync {
 					r.logger.Info("proxy and nameserver are out of sync")
 					previouslyInSync = false
 				}
+				inSyncGauge.WithLabelValues(r.name).Set(0)
 
 				now := time.Now()
-				earliestReco := r.lastReconciliation.Add(r.minimumWait)
-				if now.After(earliestReco) {
-					r.logger.Debug("starting reconciliation...")
-					err := r.Reconcile(toCreate, toDelete)
+				minimumWait := r.config().ReconciliationTimeout
+				earliestReco := r.lastReconciliation.Add(minimumWait)
+				r.mu.Lock()
+				paused, forced := r.paused, r.forced
+				r.mu.Unlock()
+				if paused {
+					// Keep diffing so that changes are applied once resumed
+				} else if r.elector != nil && !r.elector.IsLeader() {
+					// Keep diffing so that changes are applied as soon as
+					// this instance becomes the leader
+					if !standbyNoticeSent {
+						r.logger.Debug("not the leader, leaving changes to the leader")
+						standbyNoticeSent = true
+					}
+				} else if now.After(earliestReco) || forced {
+					r.logger.Debug("starting reconciliation...", "forced", forced)
+					ctx, span := tracer.Start(context.Background(), "reconcile", trace.WithAttributes(
+						attribute.String("backend", r.name),
+						attribute.Bool("forced", forced),
+						attribute.StringSlice("create", sorted(toCreate)),
+						attribute.StringSlice("delete", sorted(toDelete)),
+						attribute.StringSlice("update", sorted(toUpdate)),
+					))
+					err := r.Reconcile(ctx, toCreate, toDelete, toUpdate)
+					tracing.End(span, err)
+					durationGauge.WithLabelValues(r.name).Set(time.Since(now).Seconds())
+					r.recordHistory(now, toCreate, toDelete, toUpdate, err)
+					quarantined := 0
+					r.mu.Lock()
+					r.lastError = err
+					r.forced = false
 					if err != nil {
+						errorCounter.WithLabelValues(r.name).Inc()
 						r.logger.Error("error during reconciliation, will attempt again", "err", err)
-					} else {
-						r.deletionQueue = mapset.NewSet[string]()
+					} else if !r.failures.retriesPending() {
 						r.needsDiff = false
+						// Quarantined changes aren't attempted again, but they
+						// still keep the backend out of sync
+						quarantined = r.failures.quarantinedCount()
+						if quarantined == 0 {
+							inSyncGauge.WithLabelValues(r.name).Set(1)
+						}
+					}
+					r.mu.Unlock()
+					if err != nil {
+						r.health.Fail(err)
+					} else if quarantined > 0 {
+						r.health.Fail(fmt.Errorf("%d record changes quarantined after too many failures", quarantined))
+					} else {
+						r.health.Succeed()
+						lastSuccessGauge.WithLabelValues(r.name).SetToCurrentTime()
 					}
 					tooEarlyWarningSent = false
+					standbyNoticeSent = false
 				} else if !tooEarlyWarningSent {
 					r.logger.Debug(
 						"not enough time has passed since the last reconciliation",
-						"minimum_wait", r.minimumWait,
+						"minimum_wait", minimumWait,
 						"next_attempt_in", earliestReco.Sub(now).Round(time.Second),
 					)
 					tooEarlyWarningSent = true
@@ -187,6 +792,10 @@ func (r *Reconciler) Run() error {
 			}
 		}
 
-		time.Sleep(r.loopTimeout)
+		time.Sleep(r.config().ReconcilerLoopTimeout)
 	}
 }
+
+func isEmpty(domains mapset.Set[string]) bool {
+	return domains == nil || domains.Cardinality() == 0
+}
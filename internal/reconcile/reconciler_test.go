@@ -0,0 +1,178 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/config"
+	"github.com/n6g7/bingo/internal/nameserver"
+	"github.com/n6g7/bingo/internal/overrides"
+	"github.com/n6g7/bingo/internal/proxy"
+	"github.com/n6g7/nomtail/pkg/log"
+)
+
+const testTarget = "proxy.lan"
+
+// A nameserver keeping records in memory, rejecting changes to some names.
+type fakeNameserver struct {
+	mu       sync.Mutex
+	records  map[string]nameserver.Record
+	rejected mapset.Set[string]
+	calls    []string
+}
+
+func newFakeNameserver(rejected ...string) *fakeNameserver {
+	return &fakeNameserver{
+		records:  map[string]nameserver.Record{},
+		rejected: mapset.NewSet(rejected...),
+	}
+}
+
+func (f *fakeNameserver) Init(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeNameserver) ListRecords(ctx context.Context) ([]nameserver.Record, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	records := []nameserver.Record{}
+	for _, record := range f.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (f *fakeNameserver) change(operation, name string) error {
+	f.calls = append(f.calls, operation+" "+name)
+	if f.rejected.Contains(name) {
+		return fmt.Errorf("%s rejected", name)
+	}
+	return nil
+}
+
+func (f *fakeNameserver) RemoveRecord(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.change("delete", name); err != nil {
+		return err
+	}
+	delete(f.records, name)
+	return nil
+}
+
+func (f *fakeNameserver) AddRecord(ctx context.Context, name, cname string, ttl int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.change("create", name); err != nil {
+		return err
+	}
+	f.records[name] = nameserver.Record{Name: name, Type: nameserver.CNAME, TTL: ttl, Values: []string{cname}}
+	return nil
+}
+
+func (f *fakeNameserver) UpdateRecord(ctx context.Context, name, cname string, ttl int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.change("update", name); err != nil {
+		return err
+	}
+	f.records[name] = nameserver.Record{Name: name, Type: nameserver.CNAME, TTL: ttl, Values: []string{cname}}
+	return nil
+}
+
+func (f *fakeNameserver) DefaultTTL() int64 {
+	return 0
+}
+
+// A proxy with a single host serving every domain.
+type fakeProxy struct{}
+
+func (fakeProxy) Init() error {
+	return nil
+}
+
+func (fakeProxy) DiscoverHosts() error {
+	return nil
+}
+
+func (fakeProxy) ListServices(ctx context.Context) ([]proxy.Service, error) {
+	return nil, nil
+}
+
+func (fakeProxy) GetTarget(sourceDomain string, policy config.TargetPolicy) string {
+	return testTarget
+}
+
+func (fakeProxy) IsValidTarget(domain, target string) bool {
+	return target == testTarget
+}
+
+func testConfig(t *testing.T) *config.Config {
+	rule := config.DomainRule{}
+	if err := rule.UnmarshalText([]byte("svc.local")); err != nil {
+		t.Fatal(err)
+	}
+	if err := rule.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return &config.Config{
+		ServiceDomains: []config.DomainRule{rule},
+		Retry:          config.Retry{BaseDelay: time.Minute, MaxDelay: time.Hour, QuarantineAfter: 3},
+	}
+}
+
+func newTestReconciler(t *testing.T, ns nameserver.Nameserver, conf *config.Config) *Reconciler {
+	return NewReconciler(log.SetupLogger(), "fake", ns, fakeProxy{}, nil, nil, overrides.New(), nil, nil, conf)
+}
+
+func TestReconcileContinuesPastFailures(t *testing.T) {
+	ns := newFakeNameserver("bad.svc.local", "stale.svc.local")
+	ns.records["old.svc.local"] = nameserver.Record{Name: "old.svc.local", Type: nameserver.CNAME, Values: []string{testTarget}}
+	ns.records["stale.svc.local"] = nameserver.Record{Name: "stale.svc.local", Type: nameserver.CNAME, Values: []string{testTarget}}
+	r := newTestReconciler(t, ns, testConfig(t))
+	records, _ := ns.ListRecords(context.Background())
+	r.SetNameserverRecords(records)
+
+	err := r.Reconcile(
+		context.Background(),
+		mapset.NewSet("app.svc.local", "bad.svc.local", "api.svc.local"),
+		mapset.NewSet("old.svc.local", "stale.svc.local"),
+		mapset.NewSet[string](),
+	)
+	if err == nil || !strings.HasPrefix(err.Error(), "2 record change(s) failed") {
+		t.Fatalf("Reconcile returned %v, want 2 failed changes", err)
+	}
+	for _, name := range []string{"app.svc.local", "api.svc.local"} {
+		if _, ok := ns.records[name]; !ok {
+			t.Errorf("%s wasn't created", name)
+		}
+	}
+	if _, ok := ns.records["old.svc.local"]; ok {
+		t.Errorf("old.svc.local wasn't deleted")
+	}
+
+	failed := []string{}
+	for _, change := range r.failures.list() {
+		failed = append(failed, change.Operation+" "+change.Domain)
+	}
+	if strings.Join(failed, ",") != "create bad.svc.local,delete stale.svc.local" {
+		t.Errorf("failed changes = %v", failed)
+	}
+
+	// Failed changes wait for their next attempt
+	ns.calls = nil
+	err = r.Reconcile(
+		context.Background(),
+		mapset.NewSet("bad.svc.local"),
+		mapset.NewSet("stale.svc.local"),
+		mapset.NewSet[string](),
+	)
+	if err != nil || len(ns.calls) != 0 {
+		t.Errorf("Reconcile attempted %v before the retry delay, returned %v", ns.calls, err)
+	}
+}
@@ -0,0 +1,186 @@
+package reconcile
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/config"
+)
+
+type Operation = string
+
+const (
+	CreateOperation Operation = "create"
+	DeleteOperation Operation = "delete"
+	UpdateOperation Operation = "update"
+)
+
+type changeKey struct {
+	operation Operation
+	domain    string
+}
+
+type failure struct {
+	count       int
+	nextAttempt time.Time
+	lastErr     error
+}
+
+// Keeps track of failed record changes so that they're retried with exponential
+// backoff, and quarantined after too many failures.
+type failureTracker struct {
+	mu              sync.Mutex
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	quarantineAfter int
+	failures        map[changeKey]*failure
+}
+
+func newFailureTracker(conf config.Retry) *failureTracker {
+	return &failureTracker{
+		baseDelay:       conf.BaseDelay,
+		maxDelay:        conf.MaxDelay,
+		quarantineAfter: conf.QuarantineAfter,
+		failures:        map[changeKey]*failure{},
+	}
+}
+
+// Applies reloaded retry settings, they apply from the next failure on.
+func (ft *failureTracker) setConf(conf config.Retry) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.baseDelay = conf.BaseDelay
+	ft.maxDelay = conf.MaxDelay
+	ft.quarantineAfter = conf.QuarantineAfter
+}
+
+func (ft *failureTracker) isQuarantined(f *failure) bool {
+	return ft.quarantineAfter > 0 && f.count >= ft.quarantineAfter
+}
+
+// Returns whether the change can be attempted now, and whether it's quarantined.
+func (ft *failureTracker) ready(operation Operation, domain string, now time.Time) (ready bool, quarantined bool) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	f, ok := ft.failures[changeKey{operation, domain}]
+	if !ok {
+		return true, false
+	}
+	if ft.isQuarantined(f) {
+		return false, true
+	}
+	return now.After(f.nextAttempt), false
+}
+
+// Records a failed change and schedules the next attempt. Delays double with
+// each failure, up to the maximum delay, and are jittered by up to 50%.
+func (ft *failureTracker) fail(operation Operation, domain string, err error, now time.Time) *failure {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	key := changeKey{operation, domain}
+	f, ok := ft.failures[key]
+	if !ok {
+		f = &failure{}
+		ft.failures[key] = f
+	}
+	f.count++
+	f.lastErr = err
+
+	delay := ft.baseDelay
+	for i := 1; i < f.count && delay < ft.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > ft.maxDelay {
+		delay = ft.maxDelay
+	}
+	if delay > 0 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	f.nextAttempt = now.Add(delay)
+
+	return f
+}
+
+func (ft *failureTracker) succeed(operation Operation, domain string) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	delete(ft.failures, changeKey{operation, domain})
+}
+
+// Forgets the failures of quarantined changes, so that they're attempted again
+// right away. Returns the number of released changes.
+func (ft *failureTracker) release() int {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	released := 0
+	for key, f := range ft.failures {
+		if ft.isQuarantined(f) {
+			delete(ft.failures, key)
+			released++
+		}
+	}
+	return released
+}
+
+// Forgets failures of changes that aren't needed anymore.
+func (ft *failureTracker) prune(changes map[Operation]mapset.Set[string]) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	for key := range ft.failures {
+		if domains, ok := changes[key.operation]; !ok || !domains.Contains(key.domain) {
+			delete(ft.failures, key)
+		}
+	}
+}
+
+// Returns whether some failed changes are waiting to be attempted again.
+func (ft *failureTracker) retriesPending() bool {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	for _, f := range ft.failures {
+		if !ft.isQuarantined(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the failed changes, sorted by domain.
+func (ft *failureTracker) list() []FailedChange {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	changes := []FailedChange{}
+	for key, f := range ft.failures {
+		changes = append(changes, FailedChange{
+			Operation:   key.operation,
+			Domain:      key.domain,
+			Failures:    f.count,
+			NextAttempt: f.nextAttempt,
+			Quarantined: ft.isQuarantined(f),
+			Error:       f.lastErr.Error(),
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Domain != changes[j].Domain {
+			return changes[i].Domain < changes[j].Domain
+		}
+		return changes[i].Operation < changes[j].Operation
+	})
+	return changes
+}
+
+// Returns the number of quarantined changes.
+func (ft *failureTracker) quarantinedCount() int {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	count := 0
+	for _, f := range ft.failures {
+		if ft.isQuarantined(f) {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,139 @@
+package reconcile
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/config"
+)
+
+var errTest = errors.New("test error")
+
+func TestFailureTrackerBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		delay    time.Duration // before jitter
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{6, 16 * time.Minute},
+		{7, 30 * time.Minute},
+		{20, 30 * time.Minute},
+	}
+	now := time.Unix(1700000000, 0)
+	for _, tt := range tests {
+		// Jitter is random, check its bounds on several runs
+		for run := 0; run < 50; run++ {
+			ft := newFailureTracker(config.Retry{BaseDelay: 30 * time.Second, MaxDelay: 30 * time.Minute})
+			var f *failure
+			for i := 0; i < tt.failures; i++ {
+				f = ft.fail(CreateOperation, "app.svc.local", errTest, now)
+			}
+			delay := f.nextAttempt.Sub(now)
+			if delay < tt.delay/2 || delay > tt.delay {
+				t.Fatalf("delay after %d failures = %s, want between %s and %s", tt.failures, delay, tt.delay/2, tt.delay)
+			}
+		}
+	}
+}
+
+func TestFailureTrackerReady(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ft := newFailureTracker(config.Retry{BaseDelay: time.Minute, MaxDelay: time.Hour})
+	f := ft.fail(UpdateOperation, "app.svc.local", errTest, now)
+
+	tests := []struct {
+		operation Operation
+		domain    string
+		now       time.Time
+		want      bool
+	}{
+		{UpdateOperation, "app.svc.local", now, false},
+		{UpdateOperation, "app.svc.local", f.nextAttempt.Add(-time.Second), false},
+		{UpdateOperation, "app.svc.local", f.nextAttempt.Add(time.Second), true},
+		{CreateOperation, "app.svc.local", now, true},
+		{UpdateOperation, "other.svc.local", now, true},
+	}
+	for _, tt := range tests {
+		ready, quarantined := ft.ready(tt.operation, tt.domain, tt.now)
+		if ready != tt.want || quarantined {
+			t.Errorf("ready(%s, %s, %s) = %t, %t, want %t, false", tt.operation, tt.domain, tt.now, ready, quarantined, tt.want)
+		}
+	}
+
+	ft.succeed(UpdateOperation, "app.svc.local")
+	if ready, _ := ft.ready(UpdateOperation, "app.svc.local", now); !ready {
+		t.Errorf("change not ready after succeeding")
+	}
+}
+
+func TestFailureTrackerQuarantine(t *testing.T) {
+	tests := []struct {
+		quarantineAfter int
+		failures        int
+		want            bool
+	}{
+		{3, 1, false},
+		{3, 2, false},
+		{3, 3, true},
+		{3, 5, true},
+		{0, 100, false},
+	}
+	now := time.Unix(1700000000, 0)
+	for _, tt := range tests {
+		ft := newFailureTracker(config.Retry{BaseDelay: time.Second, MaxDelay: time.Minute, QuarantineAfter: tt.quarantineAfter})
+		for i := 0; i < tt.failures; i++ {
+			ft.fail(DeleteOperation, "app.svc.local", errTest, now)
+		}
+		ready, quarantined := ft.ready(DeleteOperation, "app.svc.local", now.Add(time.Hour))
+		if quarantined != tt.want || ready == tt.want {
+			t.Errorf("after %d failures with quarantine after %d: ready = %t, quarantined = %t", tt.failures, tt.quarantineAfter, ready, quarantined)
+		}
+		if pending := ft.retriesPending(); pending == tt.want {
+			t.Errorf("after %d failures with quarantine after %d: retries pending = %t", tt.failures, tt.quarantineAfter, pending)
+		}
+		wantCount := 0
+		if tt.want {
+			wantCount = 1
+		}
+		if count := ft.quarantinedCount(); count != wantCount {
+			t.Errorf("after %d failures with quarantine after %d: %d quarantined changes, want %d", tt.failures, tt.quarantineAfter, count, wantCount)
+		}
+
+		// Released changes are attempted right away
+		if released := ft.release(); released != wantCount {
+			t.Errorf("released %d changes, want %d", released, wantCount)
+		}
+		if tt.want {
+			if ready, quarantined := ft.ready(DeleteOperation, "app.svc.local", now); !ready || quarantined {
+				t.Errorf("released change: ready = %t, quarantined = %t", ready, quarantined)
+			}
+		}
+	}
+}
+
+func TestFailureTrackerPrune(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ft := newFailureTracker(config.Retry{BaseDelay: time.Second, MaxDelay: time.Minute})
+	ft.fail(CreateOperation, "kept.svc.local", errTest, now)
+	ft.fail(CreateOperation, "gone.svc.local", errTest, now)
+	ft.fail(DeleteOperation, "kept.svc.local", errTest, now)
+	ft.fail(UpdateOperation, "gone.svc.local", errTest, now)
+
+	ft.prune(map[Operation]mapset.Set[string]{
+		CreateOperation: mapset.NewSet("kept.svc.local"),
+		DeleteOperation: mapset.NewSet[string](),
+		UpdateOperation: mapset.NewSet("kept.svc.local"),
+	})
+
+	got := []string{}
+	for _, change := range ft.list() {
+		got = append(got, change.Operation+" "+change.Domain)
+	}
+	if len(got) != 1 || got[0] != "create kept.svc.local" {
+		t.Errorf("failures after pruning = %v, want [create kept.svc.local]", got)
+	}
+}
@@ -0,0 +1,176 @@
+package reconcile
+
+import (
+	"sort"
+	"time"
+
+	mapset "github.com/deckarep/golang-set/v2"
+	"github.com/n6g7/bingo/internal/nameserver"
+	"golang.org/x/exp/slices"
+)
+
+// How long deleted records are kept in the last changes.
+const deletedChangeRetention = time.Hour
+
+// Number of reconciliations kept in the history.
+const historySize = 20
+
+// The last change bingo made to a domain's record.
+type Change struct {
+	Operation Operation `json:"operation"`
+	// Target of the created or updated record.
+	Target string    `json:"target,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// The changes found by the last diff.
+type DiffStatus struct {
+	Time   time.Time `json:"time"`
+	Create []string  `json:"create"`
+	Delete []string  `json:"delete"`
+	Update []string  `json:"update"`
+}
+
+// A past reconciliation, with the changes it attempted.
+type HistoryEntry struct {
+	Time            time.Time `json:"time"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Create          []string  `json:"create"`
+	Delete          []string  `json:"delete"`
+	Update          []string  `json:"update"`
+	Error           string    `json:"error,omitempty"`
+}
+
+type PendingDeletionStatus struct {
+	Domain string    `json:"domain"`
+	Since  time.Time `json:"since"`
+	Polls  int       `json:"polls"`
+}
+
+type FailedChange struct {
+	Operation   Operation `json:"operation"`
+	Domain      string    `json:"domain"`
+	Failures    int       `json:"failures"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Quarantined bool      `json:"quarantined"`
+	Error       string    `json:"error"`
+}
+
+// A snapshot of the reconciler's state.
+type Status struct {
+	Backend            string                   `json:"backend"`
+	Paused             bool                     `json:"paused"`
+	ProxyDomains       []string                 `json:"proxy_domains"`
+	Records            []nameserver.Record      `json:"records"`
+	Diff               *DiffStatus              `json:"diff"`
+	Drift              map[string]DriftCategory `json:"drift"`
+	DeletionQueue      []string                 `json:"deletion_queue"`
+	RetargetQueue      []string                 `json:"retarget_queue"`
+	PendingDeletions   []PendingDeletionStatus  `json:"pending_deletions"`
+	FailedChanges      []FailedChange           `json:"failed_changes"`
+	LastChanges        map[string]Change        `json:"last_changes"`
+	History            []HistoryEntry           `json:"history"`
+	LastReconciliation *time.Time               `json:"last_reconciliation,omitempty"`
+	LastError          string                   `json:"last_error,omitempty"`
+}
+
+func sorted(domains mapset.Set[string]) []string {
+	if domains == nil {
+		return []string{}
+	}
+	slice := domains.ToSlice()
+	sort.Strings(slice)
+	return slice
+}
+
+// Records a change made to a domain's record.
+func (r *Reconciler) recordChange(domain string, operation Operation, target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastChanges[domain] = Change{
+		Operation: operation,
+		Target:    target,
+		Time:      time.Now(),
+	}
+}
+
+// Adds a reconciliation to the history, dropping the oldest ones.
+func (r *Reconciler) recordHistory(start time.Time, toCreate, toDelete, toUpdate mapset.Set[string], err error) {
+	entry := HistoryEntry{
+		Time:            start,
+		DurationSeconds: time.Since(start).Seconds(),
+		Create:          sorted(toCreate),
+		Delete:          sorted(toDelete),
+		Update:          sorted(toUpdate),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.history = append(r.history, entry)
+	if len(r.history) > historySize {
+		r.history = r.history[len(r.history)-historySize:]
+	}
+}
+
+// Forgets about records deleted a while ago.
+// Must be called with the lock held.
+func (r *Reconciler) pruneChanges(now time.Time) {
+	for domain, change := range r.lastChanges {
+		if change.Operation == DeleteOperation && now.Sub(change.Time) > deletedChangeRetention {
+			delete(r.lastChanges, domain)
+		}
+	}
+}
+
+func (r *Reconciler) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{
+		Backend:          r.name,
+		Paused:           r.paused,
+		ProxyDomains:     sorted(r.proxyDomains),
+		Records:          []nameserver.Record{},
+		Diff:             r.lastDiff,
+		Drift:            map[string]DriftCategory{},
+		DeletionQueue:    sorted(r.deletionQueue),
+		RetargetQueue:    sorted(r.retargetQueue),
+		PendingDeletions: []PendingDeletionStatus{},
+		FailedChanges:    r.failures.list(),
+		LastChanges:      map[string]Change{},
+		History:          slices.Clone(r.history),
+	}
+	if status.History == nil {
+		status.History = []HistoryEntry{}
+	}
+	for _, domain := range sorted(r.nameserverDomains) {
+		status.Records = append(status.Records, r.nameserverRecords[domain])
+	}
+	for domain, category := range r.drift {
+		status.Drift[domain] = category
+	}
+	for domain, p := range r.pendingDeletions {
+		status.PendingDeletions = append(status.PendingDeletions, PendingDeletionStatus{
+			Domain: domain,
+			Since:  p.since,
+			Polls:  p.polls,
+		})
+	}
+	sort.Slice(status.PendingDeletions, func(i, j int) bool {
+		return status.PendingDeletions[i].Domain < status.PendingDeletions[j].Domain
+	})
+	for domain, change := range r.lastChanges {
+		status.LastChanges[domain] = change
+	}
+	if !r.lastReconciliation.Equal(time.Unix(0, 0)) {
+		lastReconciliation := r.lastReconciliation
+		status.LastReconciliation = &lastReconciliation
+	}
+	if r.lastError != nil {
+		status.LastError = r.lastError.Error()
+	}
+	return status
+}
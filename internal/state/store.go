@@ -0,0 +1,194 @@
+// Package state persists what bingo knows about the domains it manages, so
+// that it survives restarts.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/n6g7/nomtail/pkg/log"
+)
+
+const fileVersion = 1
+
+type Domain struct {
+	// Target of the record bingo created, empty if it didn't create one.
+	Target string `json:"target,omitempty"`
+	// When bingo created the record, zero if it didn't create one.
+	CreatedAt time.Time `json:"created_at"`
+	// When the domain was last served by a proxy.
+	LastSeen time.Time `json:"last_seen"`
+}
+
+func (d *Domain) Owned() bool {
+	return !d.CreatedAt.IsZero()
+}
+
+type file struct {
+	Version int `json:"version"`
+	// Backend name -> domain -> state
+	Backends map[string]map[string]*Domain `json:"backends"`
+}
+
+// A JSON file backed store of domain states, per nameserver backend.
+type Store struct {
+	mu       sync.Mutex
+	logger   *log.Logger
+	path     string
+	backends map[string]map[string]*Domain
+	dirty    bool
+}
+
+// Loads the store from the file at path, starting empty if it doesn't exist.
+func Load(logger *log.Logger, path string) (*Store, error) {
+	s := &Store{
+		logger:   logger.With("component", "state"),
+		path:     path,
+		backends: map[string]map[string]*Domain{},
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.logger.Info("no state file, starting from an empty state", "path", path)
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+
+	f := file{}
+	if err := json.Unmarshal(content, &f); err != nil {
+		return nil, fmt.Errorf("error parsing state file: %w", err)
+	}
+	if f.Version != fileVersion {
+		return nil, fmt.Errorf("unsupported state file version %d", f.Version)
+	}
+	if f.Backends != nil {
+		s.backends = f.Backends
+	}
+	s.logger.Info("loaded state file", "path", path)
+	return s, nil
+}
+
+// Writes the store to disk if it changed since it was last saved. The file is
+// replaced atomically.
+func (s *Store) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(file{Version: fileVersion, Backends: s.backends}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("error replacing state file: %w", err)
+	}
+
+	s.dirty = false
+	s.logger.Trace("saved state file", "path", s.path)
+	return nil
+}
+
+// Periodically saves the store.
+func (s *Store) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := s.Save(); err != nil {
+			s.logger.Error("error saving state", "err", err)
+		}
+	}
+}
+
+// Must be called with the lock held.
+func (s *Store) domain(backend, domain string) *Domain {
+	domains, ok := s.backends[backend]
+	if !ok {
+		domains = map[string]*Domain{}
+		s.backends[backend] = domains
+	}
+	d, ok := domains[domain]
+	if !ok {
+		d = &Domain{}
+		domains[domain] = d
+	}
+	return d
+}
+
+// Returns a copy of the domain state, and whether the store knows the domain.
+func (s *Store) Get(backend, domain string) (Domain, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.backends[backend][domain]
+	if !ok {
+		return Domain{}, false
+	}
+	return *d, true
+}
+
+// Returns a copy of all domain states of the backend.
+func (s *Store) Domains(backend string) map[string]Domain {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	domains := map[string]Domain{}
+	for name, d := range s.backends[backend] {
+		domains[name] = *d
+	}
+	return domains
+}
+
+// Records that the domains are currently served by a proxy. Domains bingo
+// didn't create a record for are forgotten once they're not served anymore.
+func (s *Store) Seen(backend string, domains []string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := map[string]bool{}
+	for _, domain := range domains {
+		s.domain(backend, domain).LastSeen = now
+		seen[domain] = true
+	}
+	for domain, d := range s.backends[backend] {
+		if !seen[domain] && !d.Owned() {
+			delete(s.backends[backend], domain)
+		}
+	}
+	s.dirty = true
+}
+
+// Records that bingo created or updated the domain's record.
+func (s *Store) Written(backend, domain, target string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.domain(backend, domain)
+	if !d.Owned() {
+		d.CreatedAt = now
+	}
+	d.Target = target
+	s.dirty = true
+}
+
+// Forgets about the domain, after its record was deleted.
+func (s *Store) Forget(backend, domain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.backends[backend], domain)
+	s.dirty = true
+}